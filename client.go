@@ -0,0 +1,298 @@
+package sproto
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// default reconnection backoff of Client
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// Dialer opens a connection for Client.
+type Dialer func(ctx context.Context) (io.ReadWriteCloser, error)
+
+type ClientState int
+
+const (
+	ClientDisconnected ClientState = iota
+	ClientConnecting
+	ClientConnected
+	ClientClosed
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case ClientDisconnected:
+		return "disconnected"
+	case ClientConnecting:
+		return "connecting"
+	case ClientConnected:
+		return "connected"
+	case ClientClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// OnStateChange is called in order when the state of a Client changes, err
+// is why it's disconnected.
+type OnStateChange func(state ClientState, err error)
+
+// Client keeps a Service connected by dialing again with exponential backoff
+// once the connection drops. Calls pending on a dropped connection fail with
+// ErrServiceClosed or the error writing their requests, or are retried on the
+// next connection if their protocol is idempotent and retry is enabled.
+type Client struct {
+	protocols     []*Protocol
+	rpc           *Rpc // to look up protocols
+	dial          Dialer
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	retry         bool
+	onConnect     OnConnect
+	onStateChange OnStateChange
+
+	ctx    context.Context // canceled by Close
+	cancel context.CancelFunc
+
+	mutex   sync.Mutex
+	state   ClientState
+	service *Service      // nil if disconnected
+	changed chan struct{} // closed and renewed when service changes
+	started bool
+}
+
+func NewClient(protocols []*Protocol, dial Dialer) (*Client, error) {
+	rpc, err := NewRpc(protocols)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		protocols:  protocols,
+		rpc:        rpc,
+		dial:       dial,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		ctx:        ctx,
+		cancel:     cancel,
+		changed:    make(chan struct{}),
+	}, nil
+}
+
+// SetBackoff sets the delays between dials, it doubles from min to max.
+func (c *Client) SetBackoff(min, max time.Duration) {
+	c.minBackoff = min
+	c.maxBackoff = max
+}
+
+// SetRetryIdempotent sets whether calls of idempotent protocols are retried
+// after reconnection.
+func (c *Client) SetRetryIdempotent(retry bool) {
+	c.retry = retry
+}
+
+// SetOnConnect sets the hook configuring the service of every connection,
+// e.g. registering handlers of server pushes.
+func (c *Client) SetOnConnect(onConnect OnConnect) {
+	c.onConnect = onConnect
+}
+
+func (c *Client) SetOnStateChange(onStateChange OnStateChange) {
+	c.onStateChange = onStateChange
+}
+
+// Start connects in background, setters should be called before.
+func (c *Client) Start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.started || c.state == ClientClosed {
+		return
+	}
+	c.started = true
+	go c.run()
+}
+
+func (c *Client) State() ClientState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// Service returns the service of current connection, or nil.
+func (c *Client) Service() *Service {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.service
+}
+
+// setState is only called by the connecting goroutine
+func (c *Client) setState(state ClientState, err error) {
+	c.mutex.Lock()
+	if c.state == ClientClosed {
+		c.mutex.Unlock()
+		return
+	}
+	c.state = state
+	c.mutex.Unlock()
+	if c.onStateChange != nil {
+		c.onStateChange(state, err)
+	}
+}
+
+func (c *Client) setService(s *Service) {
+	c.mutex.Lock()
+	c.service = s
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mutex.Unlock()
+}
+
+func (c *Client) connect() (*Service, error) {
+	conn, err := c.dial(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewService(conn, c.protocols)
+	if err == nil && c.onConnect != nil {
+		err = c.onConnect(s)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff
+	for i := 0; i < attempt && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d
+}
+
+func (c *Client) run() {
+	defer c.setState(ClientClosed, nil)
+	for attempt := 0; ; {
+		c.setState(ClientConnecting, nil)
+		s, err := c.connect()
+		if err != nil {
+			c.setState(ClientDisconnected, err)
+			timer := time.NewTimer(c.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-c.ctx.Done():
+				timer.Stop()
+				return
+			}
+			attempt++
+			continue
+		}
+
+		attempt = 0
+		c.setService(s)
+		c.setState(ClientConnected, nil)
+		if c.ctx.Err() != nil {
+			// closed meanwhile
+			s.Close()
+		}
+		err = s.Dispatch()
+		c.setService(nil)
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.setState(ClientDisconnected, err)
+	}
+}
+
+// current waits for a connected service
+func (c *Client) current(ctx context.Context) (*Service, error) {
+	for {
+		c.mutex.Lock()
+		s, changed := c.service, c.changed
+		c.mutex.Unlock()
+		if s != nil && !s.isClosed() {
+			return s, nil
+		}
+		select {
+		case <-changed:
+		case <-c.ctx.Done():
+			return nil, ErrClientClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// CallContext calls on current connection, it waits for connection if it's
+// disconnected.
+func (c *Client) CallContext(ctx context.Context, name string, req interface{}) (interface{}, error) {
+	protocol := c.rpc.GetProtocolByName(name)
+	for {
+		s, err := c.current(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.CallContext(ctx, name, req)
+		if err != nil && c.retry && protocol != nil && protocol.Idempotent && c.dropped(ctx, s, err) {
+			continue
+		}
+		return resp, err
+	}
+}
+
+// dropped reports whether a call failed with err because the connection of s
+// dropped, e.g. its request can't be written
+func (c *Client) dropped(ctx context.Context, s *Service, err error) bool {
+	if errors.Is(err, ErrServiceClosed) {
+		return true
+	}
+	var re *RemoteError
+	return !errors.As(err, &re) && ctx.Err() == nil && s.isClosed()
+}
+
+func (c *Client) Call(name string, req interface{}) (interface{}, error) {
+	return c.CallContext(context.Background(), name, req)
+}
+
+// InvokeContext invokes a service which has not a reply on current
+// connection, it waits for connection until ctx is done if it's disconnected.
+func (c *Client) InvokeContext(ctx context.Context, name string, req interface{}) error {
+	s, err := c.current(ctx)
+	if err != nil {
+		return err
+	}
+	return s.InvokeContext(ctx, name, req)
+}
+
+// Invoke is InvokeContext without deadline, it waits for connection until
+// the client is closed.
+func (c *Client) Invoke(name string, req interface{}) error {
+	return c.InvokeContext(context.Background(), name, req)
+}
+
+// Close stops reconnecting and closes current connection.
+func (c *Client) Close() error {
+	c.cancel()
+	c.mutex.Lock()
+	s := c.service
+	started := c.started
+	if !started {
+		c.state = ClientClosed
+	}
+	c.mutex.Unlock()
+	if s != nil {
+		return s.Close()
+	}
+	return nil
+}
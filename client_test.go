@@ -0,0 +1,219 @@
+package sproto
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Flaky struct {
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+// drop the connection on the first call of every protocol
+func (f *Flaky) handle(ctx context.Context, req *FoobarRequest, resp *FoobarResponse) error {
+	name := ProtocolFromContext(ctx).Name
+	f.mutex.Lock()
+	f.calls[name]++
+	first := f.calls[name] == 1
+	f.mutex.Unlock()
+	if first {
+		ServiceFromContext(ctx).Close()
+		return nil
+	}
+	resp.What = req.What
+	return nil
+}
+
+func (f *Flaky) Once(ctx context.Context, req *FoobarRequest, resp *FoobarResponse) error {
+	return f.handle(ctx, req, resp)
+}
+
+func (f *Flaky) Twice(ctx context.Context, req *FoobarRequest, resp *FoobarResponse) error {
+	return f.handle(ctx, req, resp)
+}
+
+var flakyProtocols = []*Protocol{
+	{
+		Type:       1,
+		Name:       "flaky.once",
+		MethodName: "Flaky.Once",
+		Request:    reflect.TypeOf(&FoobarRequest{}),
+		Response:   reflect.TypeOf(&FoobarResponse{}),
+		Idempotent: true,
+	},
+	{
+		Type:       2,
+		Name:       "flaky.twice",
+		MethodName: "Flaky.Twice",
+		Request:    reflect.TypeOf(&FoobarRequest{}),
+		Response:   reflect.TypeOf(&FoobarResponse{}),
+	},
+}
+
+func pipeDialer(srv *Server, dials *int32) Dialer {
+	var mutex sync.Mutex
+	return func(ctx context.Context) (io.ReadWriteCloser, error) {
+		mutex.Lock()
+		*dials++
+		n := *dials
+		mutex.Unlock()
+		if n == 1 {
+			return nil, errors.New("first dial fails")
+		}
+		local, remote := net.Pipe()
+		go srv.ServeConn(remote)
+		return local, nil
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv, _ := NewServer(flakyProtocols)
+	srv.Register(&Flaky{calls: make(map[string]int)})
+	defer srv.Close()
+
+	var dials int32
+	client, _ := NewClient(flakyProtocols, pipeDialer(srv, &dials))
+	client.SetBackoff(time.Millisecond, 5*time.Millisecond)
+	client.SetRetryIdempotent(true)
+	states := make(chan ClientState, 64)
+	client.SetOnStateChange(func(state ClientState, err error) {
+		states <- state
+	})
+	client.Start()
+
+	// idempotent call is retried on the next connection
+	resp, err := client.Call("flaky.once", &FoobarRequest{What: String("once")})
+	if err != nil || *resp.(*FoobarResponse).What != "once" {
+		t.Fatalf("unexpected response:%v, err:%v", resp, err)
+	}
+
+	// others fail
+	if _, err = client.Call("flaky.twice", &FoobarRequest{What: String("twice")}); err != ErrServiceClosed {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	resp, err = client.Call("flaky.twice", &FoobarRequest{What: String("twice")})
+	if err != nil || *resp.(*FoobarResponse).What != "twice" {
+		t.Fatalf("unexpected response:%v, err:%v", resp, err)
+	}
+
+	client.Close()
+	if _, err = client.Call("flaky.once", nil); err != ErrClientClosed {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	expected := []ClientState{
+		ClientConnecting, ClientDisconnected, // first dial fails
+		ClientConnecting, ClientConnected, ClientDisconnected, // flaky.once
+		ClientConnecting, ClientConnected, ClientDisconnected, // flaky.twice
+		ClientConnecting, ClientConnected, ClientClosed,
+	}
+	for i, state := range expected {
+		select {
+		case s := <-states:
+			if s != state {
+				t.Fatalf("state %d: expected %s, got %s", i, state, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("state %d: expected %s", i, state)
+		}
+	}
+	if dials != 4 {
+		t.Fatalf("unexpected dials:%d", dials)
+	}
+}
+
+func TestClientWaitContext(t *testing.T) {
+	client, _ := NewClient(flakyProtocols, func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return nil, errors.New("unreachable")
+	})
+	client.SetBackoff(time.Millisecond, time.Millisecond)
+	client.Start()
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.CallContext(ctx, "flaky.once", nil); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	client, _ := NewClient(flakyProtocols, nil)
+	client.SetBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for attempt, d := range []time.Duration{10, 20, 40, 50, 50} {
+		if b := client.backoff(attempt); b != d*time.Millisecond {
+			t.Fatalf("attempt %d: unexpected backoff %s", attempt, b)
+		}
+	}
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+// brokenConn fails writes as a dropped connection
+type brokenConn struct {
+	net.Conn
+}
+
+func (c brokenConn) Write(p []byte) (int, error) {
+	return 0, errBrokenPipe
+}
+
+func TestClientRetryWriteError(t *testing.T) {
+	srv, _ := NewServer(flakyProtocols)
+	// both protocols succeed at the first call
+	srv.Register(&Flaky{calls: map[string]int{"flaky.once": 1, "flaky.twice": 1}})
+	defer srv.Close()
+
+	var dials int32
+	client, _ := NewClient(flakyProtocols, func(ctx context.Context) (io.ReadWriteCloser, error) {
+		n := atomic.AddInt32(&dials, 1)
+		local, remote := net.Pipe()
+		go srv.ServeConn(remote)
+		if n%2 == 1 {
+			return brokenConn{local}, nil
+		}
+		return local, nil
+	})
+	client.SetBackoff(time.Millisecond, time.Millisecond)
+	client.SetRetryIdempotent(true)
+	client.Start()
+	defer client.Close()
+
+	// idempotent call is retried on the next connection
+	resp, err := client.Call("flaky.once", &FoobarRequest{What: String("once")})
+	if err != nil || *resp.(*FoobarResponse).What != "once" {
+		t.Fatalf("unexpected response:%v, err:%v", resp, err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("unexpected dials:%d", n)
+	}
+
+	// others fail with the write error
+	client.Service().Close()
+	if _, err = client.Call("flaky.twice", &FoobarRequest{What: String("twice")}); err != errBrokenPipe {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
+
+func TestClientInvokeContext(t *testing.T) {
+	client, _ := NewClient(flakyProtocols, func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return nil, errors.New("unreachable")
+	})
+	client.SetBackoff(time.Millisecond, time.Millisecond)
+	client.Start()
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.InvokeContext(ctx, "flaky.once", nil); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xjdrew/gosproto/schema"
+)
+
+type generator struct {
+	schema  *schema.Schema
+	sources []string          // base names of the generated files
+	pkg     string            // go package name
+	name    string            // module name, prefix of protocol names
+	value   bool              // use value types for scalar fields
+	marshal bool              // generate MarshalSproto and UnmarshalSproto methods
+	imports map[string]string // schema file -> go import path
+
+	goNames map[string]*schema.Type
+	used    map[string]bool // import paths used by the generated code
+	buf     bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// camelCase turns snake_case and dotted names into exported go names:
+// card_num -> CardNum, Person.PhoneNumber -> PersonPhoneNumber
+func camelCase(name string) string {
+	var sb strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '.' }) {
+		sb.WriteString(strings.ToUpper(part[:1]))
+		sb.WriteString(part[1:])
+	}
+	return sb.String()
+}
+
+// package name of an import path
+func importName(importPath string) string {
+	name := path.Base(importPath)
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+	return name
+}
+
+func (g *generator) importPath(t *schema.Type) (string, bool) {
+	importPath, ok := g.imports[t.Pos.Filename]
+	return importPath, ok
+}
+
+func (g *generator) typeName(t *schema.Type) string {
+	name := camelCase(t.Name)
+	if importPath, ok := g.importPath(t); ok {
+		g.used[importPath] = true
+		return importName(importPath) + "." + name
+	}
+	return name
+}
+
+func (g *generator) scalarType(typeName string) string {
+	switch typeName {
+	case schema.Integer:
+		return "int64"
+	case schema.Boolean:
+		return "bool"
+	case schema.String:
+		return "string"
+	case schema.Binary:
+		return "[]byte"
+	case schema.Double:
+		return "float64"
+	}
+	panic("sprotogen: unknown builtin type " + typeName)
+}
+
+// builtinType maps integer(n) to float64
+func (g *generator) builtinType(f *schema.Field) string {
+	if f.Decimal > 0 {
+		return "float64"
+	}
+	return g.scalarType(f.TypeName)
+}
+
+func (g *generator) fieldType(f *schema.Field) string {
+	switch {
+	case f.IsMap():
+		key := g.scalarType(f.Key.TypeName)
+		if f.Value == nil {
+			return "map[" + key + "]*" + g.typeName(f.Type)
+		}
+		return "map[" + key + "]" + g.fieldType(f.Value)
+	case f.Array:
+		if f.IsBuiltin() {
+			return "[]" + g.builtinType(f)
+		}
+		return "[]*" + g.typeName(f.Type)
+	case f.IsBuiltin():
+		typ := g.builtinType(f)
+		if g.value || f.TypeName == schema.Binary {
+			return typ
+		}
+		return "*" + typ
+	default:
+		return "*" + g.typeName(f.Type)
+	}
+}
+
+func mapItemName(f *schema.Field) string {
+	return "mapItem" + camelCase(f.Name)
+}
+
+func (g *generator) fieldTag(f *schema.Field) string {
+	wire := f.TypeName
+	if !f.IsBuiltin() {
+		wire = "struct"
+	}
+	tag := fmt.Sprintf("%s,%d", wire, f.Tag)
+	if f.Array {
+		tag += ",array"
+	}
+	if f.Decimal > 0 {
+		tag += fmt.Sprintf(",decimal=%d", f.Decimal)
+	}
+	if f.IsMap() {
+		tag += fmt.Sprintf(",key=%d", f.Key.Tag)
+		if f.Value != nil {
+			tag += fmt.Sprintf(",value=%d,subtype=%s", f.Value.Tag, mapItemName(f))
+		}
+	}
+	return tag
+}
+
+func (g *generator) genType(t *schema.Type, name string) {
+	g.printf("type %s struct {\n", name)
+	for _, f := range t.Fields {
+		g.printf("%s %s `sproto:\"%s\"`\n", camelCase(f.Name), g.fieldType(f), g.fieldTag(f))
+	}
+	for _, f := range t.Fields {
+		if f.IsMap() && f.Value != nil {
+			g.printf("%s *%s\n", mapItemName(f), g.typeName(f.Type))
+		}
+	}
+	g.printf("}\n\n")
+
+	if g.marshal {
+		g.genMarshal(t, name)
+		g.genUnmarshal(t, name)
+	}
+}
+
+// inline request/response types are named after their protocol
+func inlineType(p *schema.Protocol, t *schema.Type, kind string) bool {
+	return t != nil && t.Name == p.Name+"."+kind
+}
+
+func (g *generator) protocolTypeName(p *schema.Protocol, t *schema.Type, kind string) string {
+	if t != nil && !inlineType(p, t, kind) {
+		return g.typeName(t)
+	}
+	return camelCase(p.Name) + camelCase(kind)
+}
+
+// declare go name of a generated type
+func (g *generator) declare(name string, t *schema.Type) error {
+	if old, ok := g.goNames[name]; ok {
+		return fmt.Errorf("%s: type %s conflicts with %s at %s as go type %s", t.Pos, t.Name, old.Name, old.Pos, name)
+	}
+	g.goNames[name] = t
+	return nil
+}
+
+// genImports writes the import declaration of packages used
+func (g *generator) genImports() {
+	g.printf("import (\n")
+	if g.used["reflect"] {
+		g.printf("\"reflect\"\n\n")
+	}
+	g.printf("\"github.com/xjdrew/gosproto\"\n")
+	var paths []string
+	for importPath := range g.used {
+		if importPath != "reflect" {
+			paths = append(paths, importPath)
+		}
+	}
+	sort.Strings(paths)
+	for _, importPath := range paths {
+		g.printf("%q\n", importPath)
+	}
+	g.printf(")\n\n")
+}
+
+func (g *generator) generate() ([]byte, error) {
+	g.goNames = make(map[string]*schema.Type)
+	g.used = make(map[string]bool)
+
+	// the body is generated first to know which packages are used
+	if err := g.genBody(); err != nil {
+		return nil, err
+	}
+	body := append([]byte{}, g.buf.Bytes()...)
+	g.buf.Reset()
+
+	g.printf("// Code generated by sprotogen. DO NOT EDIT.\n")
+	for _, source := range g.sources {
+		g.printf("// source: %s\n", source)
+	}
+	g.printf("\n/*\n   Package %s is a generated sproto package.\n*/\n", g.pkg)
+	g.printf("package %s\n\n", g.pkg)
+	g.genImports()
+	g.buf.Write(body)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("sprotogen: format generated code failed: %s", err)
+	}
+	return src, nil
+}
+
+func (g *generator) genBody() error {
+	for _, t := range g.schema.Types {
+		if _, ok := g.importPath(t); ok {
+			continue
+		}
+		name := camelCase(t.Name)
+		if err := g.declare(name, t); err != nil {
+			return err
+		}
+		g.genType(t, name)
+	}
+
+	var protocols []*schema.Protocol
+	for _, p := range g.schema.Protocols {
+		if _, ok := g.imports[p.Pos.Filename]; ok {
+			continue
+		}
+		protocols = append(protocols, p)
+
+		var inlines []*schema.Type
+		if inlineType(p, p.Request, "request") {
+			inlines = append(inlines, p.Request)
+		}
+		if inlineType(p, p.Response, "response") {
+			inlines = append(inlines, p.Response)
+		} else if p.ResponseNil {
+			// response nil is a confirmation without content
+			inlines = append(inlines, &schema.Type{Name: p.Name + ".response", Pos: p.Pos})
+		}
+		for _, t := range inlines {
+			kind := t.Name[len(p.Name)+1:]
+			name := g.protocolTypeName(p, nil, kind)
+			if err := g.declare(name, t); err != nil {
+				return err
+			}
+			g.genType(t, name)
+		}
+	}
+
+	g.printf("var Name string = %q\n", g.name)
+	g.printf("var Protocols []*sproto.Protocol = []*sproto.Protocol{\n")
+	for _, p := range protocols {
+		g.printf("&sproto.Protocol{\n")
+		g.printf("Type: %d,\n", p.Tag)
+		g.printf("Name: %q,\n", g.name+"."+p.Name)
+		g.printf("MethodName: %q,\n", camelCase(g.name)+"."+camelCase(p.Name))
+		if p.Request != nil {
+			g.used["reflect"] = true
+			g.printf("Request: reflect.TypeOf(&%s{}),\n", g.protocolTypeName(p, p.Request, "request"))
+		}
+		if p.Response != nil || p.ResponseNil {
+			g.used["reflect"] = true
+			g.printf("Response: reflect.TypeOf(&%s{}),\n", g.protocolTypeName(p, p.Response, "response"))
+		}
+		g.printf("},\n")
+	}
+	g.printf("}\n")
+	return nil
+}
+
+func newGenerator(files []string, imports map[string]string) (*generator, error) {
+	var all []*schema.File
+	g := &generator{
+		imports: make(map[string]string),
+	}
+	for _, filename := range files {
+		f, err := schema.ParseFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, f)
+		g.sources = append(g.sources, filepath.Base(filename))
+	}
+	for filename, importPath := range imports {
+		f, err := schema.ParseFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, f)
+		g.imports[filename] = importPath
+	}
+
+	s, err := schema.Resolve(all...)
+	if err != nil {
+		return nil, err
+	}
+	g.schema = s
+	return g, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func generate(t *testing.T, files []string, imports map[string]string, pkg, name string, value bool) string {
+	g, err := newGenerator(files, imports)
+	if err != nil {
+		t.Fatalf("new generator failed: %s", err)
+	}
+	g.pkg = pkg
+	g.name = name
+	g.value = value
+	src, err := g.generate()
+	if err != nil {
+		t.Fatalf("generate failed: %s", err)
+	}
+	// collapse spaces to make generated code independent of gofmt alignment
+	return strings.Join(strings.Fields(string(src)), " ")
+}
+
+func writeSchema(t *testing.T, dir, name, src string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// generated examples must be up to date
+func TestGenerateExamples(t *testing.T) {
+	cases := []struct {
+		pkg     string
+		name    string
+		marshal bool
+	}{
+		{"sproto_echo", "echo", false},
+		{"sproto_types", "types", true},
+	}
+	for _, c := range cases {
+		dir := filepath.Join("..", "..", "examples", c.pkg)
+		g, err := newGenerator([]string{filepath.Join(dir, c.name+".sproto")}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.pkg, g.name, g.marshal = c.pkg, c.name, c.marshal
+		src, err := g.generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := ioutil.ReadFile(filepath.Join(dir, c.name+".go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(src, expected) {
+			t.Fatalf("%s.go is out of date, run go generate", c.name)
+		}
+	}
+}
+
+func TestGenerateValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sprotogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := writeSchema(t, dir, "game.sproto", `
+.Item {
+	id 0 : integer
+	name 1 : string
+	icon 2 : binary
+}
+.Pair {
+	id 0 : integer
+	name 1 : string
+}
+.Bag {
+	items 0 : *Item(id)
+	names 1 : *Pair()
+}
+login 1 {
+	request {
+		token 0 : string
+	}
+	response nil
+}
+`)
+	src := generate(t, []string{filename}, nil, "game", "game", true)
+	for _, s := range []string{
+		"Id int64 `sproto:\"integer,0\"`",
+		"Name string `sproto:\"string,1\"`",
+		"Icon []byte `sproto:\"binary,2\"`",
+		"Items map[int64]*Item `sproto:\"struct,0,array,key=0\"`",
+		"Names map[int64]string `sproto:\"struct,1,array,key=0,value=1,subtype=mapItemNames\"`",
+		"mapItemNames *Pair",
+		"type LoginRequest struct { Token string `sproto:\"string,0\"` }",
+		"type LoginResponse struct { }",
+		"Name: \"game.login\"",
+		"MethodName: \"Game.Login\"",
+		"Response: reflect.TypeOf(&LoginResponse{})",
+	} {
+		if !strings.Contains(src, s) {
+			t.Fatalf("generated code should contain %q:\n%s", s, src)
+		}
+	}
+}
+
+func TestGenerateImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sprotogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	common := writeSchema(t, dir, "common.sproto", `
+.Vector {
+	x 0 : double
+	y 1 : double
+}
+`)
+	game := writeSchema(t, dir, "game.sproto", `
+.Player {
+	pos 0 : Vector
+	path 1 : *Vector
+}
+move 1 {
+	request Vector
+}
+`)
+	src := generate(t, []string{game}, map[string]string{common: "example.com/game/common-types"}, "game", "game", false)
+	for _, s := range []string{
+		"\"example.com/game/common-types\"",
+		"Pos *common_types.Vector",
+		"Path []*common_types.Vector",
+		"Request: reflect.TypeOf(&common_types.Vector{})",
+	} {
+		if !strings.Contains(src, s) {
+			t.Fatalf("generated code should contain %q:\n%s", s, src)
+		}
+	}
+	if strings.Contains(src, "type Vector struct") {
+		t.Fatalf("imported type should not be generated:\n%s", src)
+	}
+}
+
+func TestGenerateConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sprotogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := writeSchema(t, dir, "conflict.sproto", `
+.A {
+	.B {
+	}
+}
+.AB {
+}
+`)
+	g, err := newGenerator([]string{filename}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.pkg, g.name = "conflict", "conflict"
+	if _, err = g.generate(); err == nil || !strings.Contains(err.Error(), "as go type AB") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+// generated code imports only packages it uses
+func TestGenerateUnusedImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sprotogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	common := writeSchema(t, dir, "common.sproto", `
+.Vector {
+	x 0 : double
+}
+`)
+	game := writeSchema(t, dir, "game.sproto", `
+.Player {
+	name 0 : string
+}
+`)
+	src := generate(t, []string{game}, map[string]string{common: "example.com/game/common"}, "game", "game", false)
+	if !strings.Contains(src, "import ( \"github.com/xjdrew/gosproto\" )") {
+		t.Fatalf("generated code should import sproto only:\n%s", src)
+	}
+	if strings.Contains(src, "reflect") {
+		t.Fatalf("generated code should not use reflect:\n%s", src)
+	}
+}
@@ -0,0 +1,113 @@
+// Command sprotogen generates go code from sproto schema files.
+//
+// Usage:
+//
+//	sprotogen [flags] file.sproto...
+//
+// The generated file declares a go struct with sproto tags for every type,
+// nested types are named by joining the names: Person.PhoneNumber becomes
+// PersonPhoneNumber. Protocols are collected into a Protocols slice, ready to
+// be passed to sproto.NewService.
+//
+// It works well with go generate:
+//
+//	//go:generate go run github.com/xjdrew/gosproto/cmd/sprotogen -o echo.go echo.sproto
+//
+// With -marshal, every type gets MarshalSproto and UnmarshalSproto methods
+// implementing sproto.Marshaler and sproto.Unmarshaler, so that sproto.Encode
+// and sproto.Decode bypass reflection.
+//
+// Types of another schema already generated into a separate go package can be
+// referenced with -import:
+//
+//	sprotogen -import common.sproto=example.com/game/common -o game.go game.sproto
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type importFlags map[string]string
+
+func (f importFlags) String() string {
+	var pairs []string
+	for filename, importPath := range f {
+		pairs = append(pairs, filename+"="+importPath)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f importFlags) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return fmt.Errorf("expect file.sproto=importpath, got %q", s)
+	}
+	f[s[:i]] = s[i+1:]
+	return nil
+}
+
+var (
+	output  = flag.String("o", "", "output file, default is stdout")
+	pkg     = flag.String("package", "", "go package name, default is the name of the output directory")
+	name    = flag.String("name", "", "module name used as protocol name prefix, default is the base name of the first input file")
+	value   = flag.Bool("value", false, "use value types instead of pointers for scalar fields")
+	marshal = flag.Bool("marshal", false, "generate reflection free MarshalSproto and UnmarshalSproto methods")
+	imports = importFlags{}
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: sprotogen [flags] file.sproto...\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Var(imports, "import", "file.sproto=importpath, reference types of file.sproto from go package importpath, can be repeated")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	g, err := newGenerator(flag.Args(), imports)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sprotogen: %s\n", err)
+		os.Exit(1)
+	}
+
+	g.name = *name
+	if g.name == "" {
+		base := filepath.Base(flag.Arg(0))
+		g.name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	g.pkg = *pkg
+	if g.pkg == "" {
+		dir, err := filepath.Abs(filepath.Dir(*output))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sprotogen: %s\n", err)
+			os.Exit(1)
+		}
+		g.pkg = importName(filepath.Base(dir))
+	}
+	g.value = *value
+	g.marshal = *marshal
+
+	src, err := g.generate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sprotogen: %s\n", err)
+		os.Exit(1)
+	}
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "sprotogen: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/xjdrew/gosproto/schema"
+)
+
+// method suffix of sproto.MessageWriter and sproto.MessageReader
+func coderName(typeName string) string {
+	switch typeName {
+	case schema.Integer:
+		return "Int"
+	case schema.Boolean:
+		return "Bool"
+	case schema.String:
+		return "String"
+	case schema.Binary:
+		return "Bytes"
+	case schema.Double:
+		return "Double"
+	}
+	panic("sprotogen: unknown builtin type " + typeName)
+}
+
+func sliceCoderName(typeName string) string {
+	if typeName == schema.Integer {
+		return "Int64Slice"
+	}
+	return coderName(typeName) + "Slice"
+}
+
+func isPointer(goType string) bool {
+	return strings.HasPrefix(goType, "*")
+}
+
+func (g *generator) genMarshal(t *schema.Type, name string) {
+	g.printf("func (m *%s) MarshalSproto(dst []byte) ([]byte, error) {\n", name)
+	g.printf("w := sproto.NewMessageWriter(dst, %d)\n", len(t.Fields))
+	g.printf("if m == nil {\nreturn w.Bytes(), nil\n}\n")
+	for _, f := range t.Fields {
+		g.genWriteField(f)
+	}
+	g.printf("return w.Bytes(), nil\n}\n\n")
+}
+
+func (g *generator) genWriteElems(f *schema.Field, name string) {
+	g.printf("offset := w.BeginArray(%d)\n", f.Tag)
+	switch {
+	case f.IsMap() && f.Value != nil:
+		key := "k"
+		g.printf("for k, v := range %s {\n", name)
+		if isPointer(g.fieldType(f.Key)) {
+			g.printf("k := k\n")
+			key = "&k"
+		}
+		g.printf("item := &%s{%s: %s, %s: v}\n", g.typeName(f.Type), camelCase(f.Key.Name), key, camelCase(f.Value.Name))
+		g.printf("if err := w.WriteElem(item); err != nil {\nreturn dst, err\n}\n}\n")
+	default:
+		g.printf("for _, v := range %s {\n", name)
+		g.printf("if err := w.WriteElem(v); err != nil {\nreturn dst, err\n}\n}\n")
+	}
+	g.printf("w.EndArray(offset)\n")
+}
+
+func (g *generator) genWriteField(f *schema.Field) {
+	name := "m." + camelCase(f.Name)
+	goType := g.fieldType(f)
+	switch {
+	case f.Decimal > 0 && f.Array:
+		g.printf("if %s != nil {\n", name)
+		g.printf("if err := w.WriteDecimalSlice(%d, %s, %d); err != nil {\nreturn dst, err\n}\n", f.Tag, name, f.Decimal)
+		g.printf("}\n")
+	case f.Decimal > 0 && isPointer(goType):
+		g.printf("if %s != nil {\n", name)
+		g.printf("if err := w.WriteDecimal(%d, *%s, %d); err != nil {\nreturn dst, err\n}\n", f.Tag, name, f.Decimal)
+		g.printf("}\n")
+	case f.Decimal > 0:
+		g.printf("if err := w.WriteDecimal(%d, %s, %d); err != nil {\nreturn dst, err\n}\n", f.Tag, name, f.Decimal)
+	case f.Array && f.IsBuiltin():
+		g.printf("if %s != nil {\nw.Write%s(%d, %s)\n}\n", name, sliceCoderName(f.TypeName), f.Tag, name)
+	case f.Array:
+		g.printf("if %s != nil {\n", name)
+		g.genWriteElems(f, name)
+		g.printf("}\n")
+	case f.IsBuiltin() && isPointer(goType):
+		g.printf("if %s != nil {\nw.Write%s(%d, *%s)\n}\n", name, coderName(f.TypeName), f.Tag, name)
+	case f.IsBuiltin() && f.TypeName == schema.Binary:
+		g.printf("if %s != nil {\nw.WriteBytes(%d, %s)\n}\n", name, f.Tag, name)
+	case f.IsBuiltin():
+		g.printf("w.Write%s(%d, %s)\n", coderName(f.TypeName), f.Tag, name)
+	default:
+		g.printf("if %s != nil {\n", name)
+		g.printf("if err := w.WriteStruct(%d, %s); err != nil {\nreturn dst, err\n}\n", f.Tag, name)
+		g.printf("}\n")
+	}
+}
+
+func (g *generator) genUnmarshal(t *schema.Type, name string) {
+	g.printf("func (m *%s) UnmarshalSproto(data []byte) (int, error) {\n", name)
+	g.printf("return sproto.Decode(data, m)\n}\n\n")
+	g.printf("func (m *%s) UnmarshalSprotoReader(r *sproto.MessageReader) error {\n", name)
+	g.printf("*m = %s{}\n", name)
+	g.printf("for r.Next() {\nswitch r.Tag() {\n")
+	for _, f := range t.Fields {
+		g.printf("case %d:\n", f.Tag)
+		g.genReadField(f)
+	}
+	g.printf("default:\nif err := r.SkipUnknown(); err != nil {\nreturn err\n}\n")
+	g.printf("}\n}\n")
+	g.printf("return r.Err()\n}\n\n")
+}
+
+// key expression of map element v, returns error if key is nil
+func (g *generator) genMapKey(f *schema.Field) string {
+	key := "v." + camelCase(f.Key.Name)
+	if isPointer(g.fieldType(f.Key)) {
+		g.printf("if %s == nil {\nreturn sproto.ErrNilMapKey\n}\n", key)
+		key = "*" + key
+	}
+	return key
+}
+
+func (g *generator) genReadElems(f *schema.Field, name string) {
+	g.printf("arr, err := r.ReadArray()\n")
+	g.printf("if err != nil {\nreturn err\n}\n")
+	if f.IsMap() {
+		g.printf("%s = make(%s)\n", name, g.fieldType(f))
+	} else {
+		g.printf("%s = make(%s, 0)\n", name, g.fieldType(f))
+	}
+	g.printf("for arr.Next() {\n")
+	g.printf("v := new(%s)\n", g.typeName(f.Type))
+	g.printf("if err := arr.ReadStruct(v); err != nil {\nreturn err\n}\n")
+	switch {
+	case f.IsMap() && f.Value != nil:
+		key := g.genMapKey(f)
+		g.printf("%s[%s] = v.%s\n", name, key, camelCase(f.Value.Name))
+	case f.IsMap():
+		key := g.genMapKey(f)
+		g.printf("%s[%s] = v\n", name, key)
+	default:
+		g.printf("%s = append(%s, v)\n", name, name)
+	}
+	g.printf("}\n")
+	g.printf("if err := arr.Err(); err != nil {\nreturn err\n}\n")
+}
+
+func (g *generator) genReadField(f *schema.Field) {
+	name := "m." + camelCase(f.Name)
+	goType := g.fieldType(f)
+	switch {
+	case f.Decimal > 0 && f.Array:
+		g.printf("v, err := r.ReadDecimalSlice(%d)\n", f.Decimal)
+		g.printf("if err != nil {\nreturn err\n}\n")
+		g.printf("%s = v\n", name)
+	case f.Decimal > 0:
+		g.printf("v, err := r.ReadDecimal(%d)\n", f.Decimal)
+		g.printf("if err != nil {\nreturn err\n}\n")
+		if isPointer(goType) {
+			g.printf("%s = &v\n", name)
+		} else {
+			g.printf("%s = v\n", name)
+		}
+	case f.Array && f.IsBuiltin():
+		g.printf("v, err := r.Read%s()\n", sliceCoderName(f.TypeName))
+		g.printf("if err != nil {\nreturn err\n}\n")
+		g.printf("%s = v\n", name)
+	case f.Array:
+		g.genReadElems(f, name)
+	case f.IsBuiltin():
+		g.printf("v, err := r.Read%s()\n", coderName(f.TypeName))
+		g.printf("if err != nil {\nreturn err\n}\n")
+		if isPointer(goType) {
+			g.printf("%s = &v\n", name)
+		} else {
+			g.printf("%s = v\n", name)
+		}
+	default:
+		g.printf("v := new(%s)\n", g.typeName(f.Type))
+		g.printf("if err := r.ReadStruct(v); err != nil {\nreturn err\n}\n")
+		g.printf("%s = v\n", name)
+	}
+}
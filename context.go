@@ -0,0 +1,79 @@
+package sproto
+
+import (
+	"context"
+)
+
+type requestContextKey struct{}
+
+type headerContextKey struct{}
+
+// requestContext is the metadata of a request carried by handler contexts
+type requestContext struct {
+	service  *Service
+	protocol *Protocol
+	session  int32
+	header   interface{}
+}
+
+func newRequestContext(parent context.Context, s *Service, protocol *Protocol, session int32, header interface{}) context.Context {
+	return context.WithValue(parent, requestContextKey{}, &requestContext{
+		service:  s,
+		protocol: protocol,
+		session:  session,
+		header:   header,
+	})
+}
+
+func fromContext(ctx context.Context) *requestContext {
+	rc, _ := ctx.Value(requestContextKey{}).(*requestContext)
+	return rc
+}
+
+// SessionFromContext returns the session of the request handled with ctx,
+// which is 0 if the request expects no response.
+func SessionFromContext(ctx context.Context) (int32, bool) {
+	if rc := fromContext(ctx); rc != nil {
+		return rc.session, true
+	}
+	return 0, false
+}
+
+// ProtocolFromContext returns the protocol of the request handled with ctx,
+// or nil.
+func ProtocolFromContext(ctx context.Context) *Protocol {
+	if rc := fromContext(ctx); rc != nil {
+		return rc.protocol
+	}
+	return nil
+}
+
+// ServiceFromContext returns the service handling the request with ctx, or
+// nil. Its Conn gives the connection metadata, e.g. the remote address of a
+// net.Conn.
+func ServiceFromContext(ctx context.Context) *Service {
+	if rc := fromContext(ctx); rc != nil {
+		return rc.service
+	}
+	return nil
+}
+
+// HeaderFromContext returns the package header of the request handled with
+// ctx, which is nil unless SetHeaderType of the service is called.
+func HeaderFromContext(ctx context.Context) interface{} {
+	if rc := fromContext(ctx); rc != nil {
+		return rc.header
+	}
+	return nil
+}
+
+// WithHeader returns a copy of parent carrying the package header of
+// requests sent with it, e.g. by CallContext. header is of the type set by
+// SetHeaderType, its type and session fields are filled in when sent.
+func WithHeader(parent context.Context, header interface{}) context.Context {
+	return context.WithValue(parent, headerContextKey{}, header)
+}
+
+func outgoingHeader(ctx context.Context) interface{} {
+	return ctx.Value(headerContextKey{})
+}
@@ -0,0 +1,115 @@
+package sproto
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// MaxDecimal is the max n of integer(n), 10^n must fit in int64.
+const MaxDecimal = 18
+
+// decimalToInt scales v by 10^n, rounding half away from zero
+func decimalToInt(v float64, n int) (int64, error) {
+	f := math.Round(v * math.Pow10(n))
+	// also false for NaN
+	if !(f >= math.MinInt64 && f < math.MaxInt64) {
+		return 0, fmt.Errorf("%w, value: %v, decimal: %d", ErrDecimalOverflow, v, n)
+	}
+	return int64(f), nil
+}
+
+func decimalFromInt(v int64, n int) float64 {
+	return float64(v) / math.Pow10(n)
+}
+
+func mustDecimalToInt(sf *SprotoField, v float64) int64 {
+	n, err := decimalToInt(v, sf.Decimal)
+	if err != nil {
+		panic(fmt.Errorf("%w, field: %s", err, sf.field.Name))
+	}
+	return n
+}
+
+func headerEncodeDecimal(sf *SprotoField, v reflect.Value) (uint16, bool) {
+	if v.IsNil() {
+		return 0, true
+	}
+	n := mustDecimalToInt(sf, v.Elem().Float())
+	if n >= 0 && n <= MaxEmbeddedInt {
+		return uint16(2 * (n + 1)), false
+	}
+	return 0, false
+}
+
+func encodeDecimal(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	n := mustDecimalToInt(sf, v.Elem().Float())
+	if n >= MinInt32 && n <= MaxInt32 {
+		return appendUint32(dst, uint32(n))
+	}
+	return appendUint64(dst, uint64(n))
+}
+
+func encodeDecimalSlice(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	sz := v.Len()
+	if sz == 0 {
+		return dst
+	}
+	intLen := 4
+	for i := 0; i < sz; i++ {
+		if n := mustDecimalToInt(sf, v.Index(i).Float()); n < MinInt32 || n > MaxInt32 {
+			intLen = 8
+		}
+	}
+	dst = append(dst, uint8(intLen))
+	for i := 0; i < sz; i++ {
+		n := mustDecimalToInt(sf, v.Index(i).Float())
+		if intLen == 4 {
+			dst = appendUint32(dst, uint32(n))
+		} else {
+			dst = appendUint64(dst, uint64(n))
+		}
+	}
+	return dst
+}
+
+func decodeDecimal(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	var n int64
+	if val != nil {
+		n = int64(*val)
+	} else {
+		switch len(data) {
+		case 0:
+		case 4:
+			n = int64(int32(readUint32(data)))
+		case 8:
+			n = int64(readUint64(data))
+		default:
+			return decodeErr(0, errMalformedInt)
+		}
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	v.SetFloat(decimalFromInt(n, sf.Decimal))
+	return nil
+}
+
+func decodeDecimalSlice(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	if len(data) > 0 && (data[0] == 4 || data[0] == 8) {
+		if err := d.checkArray((len(data)-1)/int(data[0]), v.Type().Elem()); err != nil {
+			return err
+		}
+	}
+	ints, err := readInt64Slice(data)
+	if err != nil {
+		return decodeErr(0, errMalformedInt)
+	}
+	vals := reflect.MakeSlice(v.Type(), len(ints), len(ints))
+	for i, n := range ints {
+		vals.Index(i).SetFloat(decimalFromInt(n, sf.Decimal))
+	}
+	v.Set(vals)
+	return nil
+}
@@ -0,0 +1,155 @@
+package sproto
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type DecimalMsg struct {
+	Price  *float64  `sproto:"integer,0,decimal=2"`
+	Rate   float64   `sproto:"integer,1,decimal=4"`
+	Prices []float64 `sproto:"integer,2,array,decimal=2"`
+}
+
+// the same layout of DecimalMsg, scaled by hand
+type ScaledMsg struct {
+	Price  *int64  `sproto:"integer,0"`
+	Rate   int64   `sproto:"integer,1"`
+	Prices []int64 `sproto:"integer,2,array"`
+}
+
+func TestDecimal(t *testing.T) {
+	cases := []struct {
+		decimal *DecimalMsg
+		scaled  *ScaledMsg
+	}{
+		{
+			&DecimalMsg{Price: Double(12.34), Rate: 0.0325, Prices: []float64{0.29, -1.5, 100}},
+			&ScaledMsg{Price: Int64(1234), Rate: 325, Prices: []int64{29, -150, 10000}},
+		},
+		{
+			// rounding half away from zero
+			&DecimalMsg{Price: Double(-0.125), Rate: 1.00005, Prices: []float64{}},
+			&ScaledMsg{Price: Int64(-13), Rate: 10001, Prices: []int64{}},
+		},
+		{
+			&DecimalMsg{Rate: 1e12, Prices: []float64{1e15}},
+			&ScaledMsg{Rate: 1e16, Prices: []int64{1e17}},
+		},
+	}
+	for i, c := range cases {
+		data, err := Encode(c.decimal)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		expected := MustEncode(c.scaled)
+		if !bytes.Equal(data, expected) {
+			t.Log("encoded:", data)
+			t.Log("expected:", expected)
+			t.Fatalf("case %d: encode decimal failed", i)
+		}
+
+		var scaled ScaledMsg
+		MustDecode(data, &scaled)
+		var decimal DecimalMsg
+		MustDecode(expected, &decimal)
+		if !reflect.DeepEqual(&scaled, c.scaled) {
+			t.Fatalf("case %d: decode scaled failed: %+v", i, scaled)
+		}
+		if !bytes.Equal(MustEncode(&decimal), expected) {
+			t.Fatalf("case %d: decode decimal failed: %+v", i, decimal)
+		}
+	}
+
+	var decimal DecimalMsg
+	MustDecode(MustEncode(&ScaledMsg{Price: Int64(-1234)}), &decimal)
+	if *decimal.Price != -12.34 {
+		t.Fatalf("unexpected price: %v", *decimal.Price)
+	}
+}
+
+func TestDecimalOverflow(t *testing.T) {
+	for _, msg := range []*DecimalMsg{
+		{Price: Double(1e17)},
+		{Rate: math.NaN()},
+		{Prices: []float64{1, math.Inf(-1)}},
+	} {
+		if _, err := Encode(msg); !errors.Is(err, ErrDecimalOverflow) {
+			t.Fatalf("encode %+v should overflow, but get: %v", msg, err)
+		}
+	}
+
+	w := NewMessageWriter(nil, 1)
+	if err := w.WriteDecimal(0, 1e17, 2); !errors.Is(err, ErrDecimalOverflow) {
+		t.Fatalf("write decimal should overflow, but get: %v", err)
+	}
+}
+
+func TestDecimalTag(t *testing.T) {
+	for _, sp := range []interface{}{
+		&struct {
+			A string `sproto:"string,0,decimal=2"`
+		}{},
+		&struct {
+			A int `sproto:"integer,0,decimal=2"`
+		}{},
+		&struct {
+			A float64 `sproto:"integer,0,decimal=19"`
+		}{},
+		&struct {
+			A float64 `sproto:"integer,0,decimal=0"`
+		}{},
+	} {
+		if _, err := Encode(sp); err == nil {
+			t.Fatalf("encode %T should fail", sp)
+		}
+	}
+}
+
+func TestMessageWriterDecimal(t *testing.T) {
+	msg := &DecimalMsg{Price: Double(12.34), Rate: 3.5, Prices: []float64{0.01, -20}}
+	w := NewMessageWriter(nil, 3)
+	if err := w.WriteDecimal(0, *msg.Price, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteDecimal(1, msg.Rate, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteDecimalSlice(2, msg.Prices, 2); err != nil {
+		t.Fatal(err)
+	}
+	data := w.Bytes()
+	if !bytes.Equal(data, MustEncode(msg)) {
+		t.Fatal("MessageWriter decimal mismatch")
+	}
+
+	r, err := NewMessageReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded DecimalMsg
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadDecimal(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded.Price = &v
+		case 1:
+			if decoded.Rate, err = r.ReadDecimal(4); err != nil {
+				t.Fatal(err)
+			}
+		case 2:
+			if decoded.Prices, err = r.ReadDecimalSlice(2); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if r.Err() != nil || !reflect.DeepEqual(&decoded, msg) {
+		t.Fatalf("MessageReader decimal mismatch: %+v, %v", decoded, r.Err())
+	}
+}
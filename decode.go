@@ -1,10 +1,12 @@
 package sproto
 
 import (
+	"errors"
 	"fmt"
 	"math"
-	"os"
 	"reflect"
+	"strings"
+	"unsafe"
 )
 
 type Tag struct {
@@ -12,14 +14,64 @@ type Tag struct {
 	Val *uint16
 }
 
+// DecodeError describes where and why decoding failed, it matches ErrDecode
+// with errors.Is.
+type DecodeError struct {
+	Type   string // go type of the message containing the failed field
+	Path   string // field path from the decoded type, e.g. Bank.Clients[3].Phone[0].Number
+	Tag    int    // tag of the failed field, -1 if headers of the message are malformed
+	Offset int    // byte offset in the decoded data
+	Err    error  // reason
+}
+
+func (e *DecodeError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("sproto: decode failed at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("sproto: decode %s failed at %s (tag %d, offset %d): %v", e.Type, e.Path, e.Tag, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// reasons of DecodeError
+var (
+	errTruncatedHeader = errors.New("truncated header")
+	errTruncatedData   = errors.New("truncated data")
+	errMalformedBool   = errors.New("malformed boolean")
+	errMalformedInt    = errors.New("malformed integer")
+	errMalformedDouble = errors.New("malformed double")
+	errMalformedStruct = errors.New("malformed struct")
+	errEmbeddedValue   = errors.New("unexpected value in header")
+)
+
+// decodeErr returns an error at offset relative to the data being decoded,
+// decodeMessage completes it with the field where it happens.
+func decodeErr(offset int, reason error) error {
+	return &DecodeError{Tag: -1, Offset: offset, Err: reason}
+}
+
+// errAt moves an incomplete error by offset
+func errAt(err error, offset int) error {
+	if de, ok := err.(*DecodeError); ok && de.Type == "" {
+		de.Offset += offset
+	}
+	return err
+}
+
 func readChunk(chunk []byte) (int, []byte, error) {
 	if len(chunk) < 4 {
-		return 0, nil, ErrDecode
+		return 0, nil, decodeErr(0, errTruncatedData)
 	}
 	sz := int(readUint32(chunk))
 	expected := 4 + sz
-	if len(chunk) < expected {
-		return 0, nil, ErrDecode
+	if len(chunk) < expected || expected < 4 {
+		return 0, nil, decodeErr(0, errTruncatedData)
 	}
 	return expected, chunk[4:expected], nil
 }
@@ -53,7 +105,10 @@ func readUint64(buf []byte) uint64 {
 	return n
 }
 
-func decodeBool(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeBool(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	if val == nil {
+		return decodeErr(0, errMalformedBool)
+	}
 	b := true
 	if *val == 0 {
 		b = false
@@ -66,7 +121,7 @@ func decodeBool(val *uint16, data []byte, sf *SprotoField, v reflect.Value) erro
 	return nil
 }
 
-func decodeInt(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeInt(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
 	var n uint64
 	if val != nil {
 		n = uint64(*val)
@@ -79,7 +134,7 @@ func decodeInt(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error
 		case 8:
 			n = readUint64(data)
 		default:
-			return fmt.Errorf("sproto: malformed integer data for field %s", sf.field.Name)
+			return decodeErr(0, errMalformedInt)
 		}
 	}
 	if v.Type().Kind() == reflect.Ptr {
@@ -101,8 +156,6 @@ func decodeInt(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error
 			v.Elem().SetUint(n)
 		}
 	} else {
-		// 初始化默认值
-		v.SetInt(0)
 		switch v.Kind() {
 		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
 			switch len(data) {
@@ -122,21 +175,27 @@ func decodeInt(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error
 	return nil
 }
 
-func decodeDouble(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeDouble(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	if len(data) != DOUBLE_SZ {
+		return decodeErr(0, errMalformedDouble)
+	}
 	n := readUint64(data)
-	d := math.Float64frombits(n)
+	f := math.Float64frombits(n)
 	if v.Kind() == reflect.Ptr {
 		e := v.Type().Elem()
 		v.Addr().Elem().Set(reflect.New(e))
-		v.Elem().SetFloat(d)
+		v.Elem().SetFloat(f)
 	} else {
-		v.SetFloat(d)
+		v.SetFloat(f)
 	}
 	return nil
 }
 
-func decodeString(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
-	str := string(data)
+func decodeString(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	str, err := d.string(data)
+	if err != nil {
+		return err
+	}
 	if v.Kind() == reflect.Ptr {
 		*v.Addr().Interface().(**string) = &str
 	} else {
@@ -146,14 +205,19 @@ func decodeString(val *uint16, data []byte, sf *SprotoField, v reflect.Value) er
 	return nil
 }
 
-func decodeBytes(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
-	buf := make([]byte, len(data))
-	copy(buf, data)
-	v.Set(reflect.ValueOf(buf))
+func decodeBytes(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	bs, err := d.bytes(data)
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(bs))
 	return nil
 }
 
-func decodeBoolSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeBoolSlice(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	if err := d.checkArray(len(data), v.Type().Elem()); err != nil {
+		return err
+	}
 	vals := make([]bool, len(data))
 	for i, v := range data {
 		if v == 0 {
@@ -166,7 +230,7 @@ func decodeBoolSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value)
 	return nil
 }
 
-func decodeIntSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeIntSlice(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
 	dataLen := len(data)
 	intLen := 4
 
@@ -176,16 +240,20 @@ func decodeIntSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value)
 		data = data[1:]
 	}
 
-	if dataLen%intLen != 0 {
-		return fmt.Errorf("sproto: malformed integer data for field %s", sf.field.Name)
+	if (intLen != 4 && intLen != 8) || dataLen%intLen != 0 {
+		return decodeErr(0, errMalformedInt)
 	}
 
 	sz := dataLen / intLen
+	if err := d.checkArray(sz, v.Type().Elem()); err != nil {
+		return err
+	}
 	vals := reflect.MakeSlice(v.Type(), sz, sz)
 	var n uint64
 	for i := 0; i < sz; i++ {
 		if intLen == 4 {
-			n = uint64(readUint32(data[i*intLen:]))
+			// sign extend
+			n = uint64(int32(readUint32(data[i*intLen:])))
 		} else {
 			n = readUint64(data[i*intLen:])
 		}
@@ -202,18 +270,15 @@ func decodeIntSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value)
 	return nil
 }
 
-func decodeDoubleSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeDoubleSlice(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
 	dataLen := len(data)
-	if dataLen < 1 {
-		return ErrDecode
-	}
-	if int(data[0]) != DOUBLE_SZ {
-		return fmt.Errorf("sproto: malformed double slice for field %s:%d", sf.field.Name, int(data[0]))
-	}
-	if (dataLen-1)%DOUBLE_SZ != 0 {
-		return fmt.Errorf("sproto: malformed double data for field %s:%d", sf.field.Name, dataLen-1)
+	if dataLen < 1 || int(data[0]) != DOUBLE_SZ || (dataLen-1)%DOUBLE_SZ != 0 {
+		return decodeErr(0, errMalformedDouble)
 	}
 	sz := (dataLen - 1) / DOUBLE_SZ
+	if err := d.checkArray(sz, v.Type().Elem()); err != nil {
+		return err
+	}
 	vals := reflect.MakeSlice(v.Type(), sz, sz)
 	data = data[1:]
 	var n uint64
@@ -226,76 +291,109 @@ func decodeDoubleSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Valu
 	return nil
 }
 
-func decodeBytesSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeBytesSlice(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
 	vals := make([][]byte, 0, 16)
-	for len(data) > 0 {
-		expected, val, err := readChunk(data)
+	for offset := 0; offset < len(data); {
+		expected, val, err := readChunk(data[offset:])
+		if err != nil {
+			return errAt(err, offset)
+		}
+		if err = d.checkElem(len(vals), sf.field.Type.Elem()); err != nil {
+			return errAt(err, offset)
+		}
+		bs, err := d.bytes(val)
 		if err != nil {
-			return err
+			return errAt(err, offset+4)
 		}
-		vals = append(vals, val)
-		data = data[expected:]
+		vals = append(vals, bs)
+		offset += expected
 	}
 	v.Set(reflect.ValueOf(vals))
 	return nil
 }
 
-func decodeStringSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeStringSlice(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
 	vals := make([]string, 0, 16)
-	for len(data) > 0 {
-		expected, val, err := readChunk(data)
+	for offset := 0; offset < len(data); {
+		expected, val, err := readChunk(data[offset:])
 		if err != nil {
-			return err
+			return errAt(err, offset)
 		}
-		vals = append(vals, string(val))
-		data = data[expected:]
+		if err = d.checkElem(len(vals), sf.field.Type.Elem()); err != nil {
+			return errAt(err, offset)
+		}
+		str, err := d.string(val)
+		if err != nil {
+			return errAt(err, offset+4)
+		}
+		vals = append(vals, str)
+		offset += expected
 	}
 	v.Set(reflect.ValueOf(vals))
 	return nil
 }
 
-func decodeStruct(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeStruct(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	if err := d.alloc(int(sf.st.Type.Size())); err != nil {
+		return err
+	}
 	// v1: pointer to struct
 	v1 := reflect.New(v.Type().Elem())
-	used, err := decodeMessage(data, sf.st, v1)
+	start := d.offset
+	used, err := decodeMessage(d, data, sf.st, v1)
 	if err != nil {
 		return err
 	}
 	if used != len(data) {
-		return fmt.Errorf("sproto: malformed struct data for field %s", sf.field.Name)
+		d.offset = start
+		return decodeErr(used, errMalformedStruct)
 	}
 	v.Addr().Elem().Set(v1)
 	return nil
 }
 
-func decodeStructSliceImpl(val *uint16, data []byte, sf *SprotoField, sliceType reflect.Type) (vals reflect.Value, err error) {
+func decodeStructSliceImpl(d *decodeState, val *uint16, data []byte, sf *SprotoField, sliceType reflect.Type) (vals reflect.Value, err error) {
 	vals = reflect.MakeSlice(sliceType, 0, 16)
-	for len(data) > 0 {
-		expected, buf, rerr := readChunk(data)
+	start := d.offset
+	last := len(d.path) - 1
+	for offset, i := 0, 0; offset < len(data); i++ {
+		d.path[last].index = i
+		expected, buf, rerr := readChunk(data[offset:])
+		if rerr == nil {
+			rerr = d.checkElem(i, sliceType.Elem())
+		}
+		if rerr == nil {
+			rerr = d.alloc(int(sf.st.Type.Size()))
+		}
 		if rerr != nil {
-			err = rerr
+			d.offset = start
+			err = errAt(rerr, offset)
 			return
 		}
 
 		// v1: pointer to struct
 		v1 := reflect.New(sliceType.Elem().Elem())
-		used, derr := decodeMessage(buf, sf.st, v1)
+		d.offset = start + offset + 4
+		used, derr := decodeMessage(d, buf, sf.st, v1)
 		if derr != nil {
 			err = derr
 			return
 		}
 		if used != len(buf) {
-			err = fmt.Errorf("sproto: malformed struct data for field %s", sf.field.Name)
+			d.offset = start + offset + 4
+			err = decodeErr(used, errMalformedStruct)
 			return
 		}
 		vals = reflect.Append(vals, v1)
-		data = data[expected:]
+		offset += expected
 	}
+	d.path[last].index = -1
+	d.offset = start
 	return
 }
 
-func decodeStructSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
-	vals, err := decodeStructSliceImpl(val, data, sf, v.Type())
+func decodeStructSlice(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+	vals, err := decodeStructSliceImpl(d, val, data, sf, v.Type())
 	if err != nil {
 		return err
 	}
@@ -303,10 +401,10 @@ func decodeStructSlice(val *uint16, data []byte, sf *SprotoField, v reflect.Valu
 	return nil
 }
 
-func decodeMap(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
+func decodeMap(d *decodeState, val *uint16, data []byte, sf *SprotoField, v reflect.Value) error {
 	st := sf.st
 	sliceType := reflect.SliceOf(reflect.PtrTo(st.Type))
-	vals, err := decodeStructSliceImpl(val, data, sf, sliceType)
+	vals, err := decodeStructSliceImpl(d, val, data, sf, sliceType)
 	if err != nil {
 		return err
 	}
@@ -320,7 +418,7 @@ func decodeMap(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error
 		keySprotoField := st.FieldByTag(sf.KeyTag)
 		keyVal := elem.FieldByIndex(keySprotoField.field.Index)
 		if keyVal.Kind() == reflect.Ptr && keyVal.IsNil() {
-			return fmt.Errorf("sproto: map key is nil, elem: %s%+v", elem.Type(), elem)
+			return decodeErr(0, fmt.Errorf("%w, elem: %s%+v", ErrNilMapKey, elem.Type(), elem))
 		}
 		keyVal = adjustTypePtr(keyVal, mt.Key())
 
@@ -338,14 +436,17 @@ func decodeMap(val *uint16, data []byte, sf *SprotoField, v reflect.Value) error
 	return nil
 }
 
-func decodeHeader(chunk []byte) (int, []Tag, error) {
+func decodeHeader(d *decodeState, chunk []byte) (int, []Tag, error) {
 	if len(chunk) < 2 {
-		return 0, nil, ErrDecode
+		return 0, nil, decodeErr(0, errTruncatedHeader)
 	}
 	fn := int(readUint16(chunk))
+	if d.opts.MaxFields > 0 && fn > d.opts.MaxFields {
+		return 0, nil, decodeErr(0, ErrFieldsLimit)
+	}
 	expected := 2 + fn*2
 	if len(chunk) < expected {
-		return 0, nil, ErrDecode
+		return 0, nil, decodeErr(0, errTruncatedHeader)
 	}
 	tags := make([]Tag, fn)
 	n := 0
@@ -372,38 +473,237 @@ func decodeHeader(chunk []byte) (int, []Tag, error) {
 }
 
 // v is a struct pointer
-func decodeMessage(chunk []byte, st *SprotoType, v reflect.Value) (int, error) {
+func decodeMessage(d *decodeState, chunk []byte, st *SprotoType, v reflect.Value) (int, error) {
+	if st.readerUnmarshaler {
+		return decodeReader(d, chunk, v.Interface().(ReaderUnmarshaler))
+	}
+	base := d.offset
+	if d.depth++; d.opts.MaxDepth > 0 && d.depth > d.opts.MaxDepth {
+		return 0, d.complete(decodeErr(0, ErrDepthLimit), st.Type.Name(), -1, base)
+	}
+	defer func() { d.depth-- }()
+	if st.unmarshaler {
+		used, err := v.Interface().(Unmarshaler).UnmarshalSproto(chunk)
+		if err != nil {
+			return 0, d.complete(err, st.Type.Name(), -1, base)
+		}
+		return used, nil
+	}
+
 	var total int
 	var tags []Tag
 	var err error
-	if total, tags, err = decodeHeader(chunk); err != nil {
-		return 0, err
+	if total, tags, err = decodeHeader(d, chunk); err != nil {
+		return 0, d.complete(err, st.Type.Name(), -1, base)
 	}
 
 	elem := v.Elem()
 	for _, tag := range tags {
+		sf := st.FieldByTag(int(tag.Tag))
+		if sf != nil {
+			d.path = append(d.path, pathElem{name: sf.field.Name, index: -1})
+		}
 		var used int
 		var data []byte
+		offset := base
 		if tag.Val == nil {
 			if used, data, err = readChunk(chunk[total:]); err != nil {
-				return 0, err
+				return 0, d.complete(err, st.Type.Name(), int(tag.Tag), base+total)
 			}
+			offset = base + total + 4
 			total += used
 		}
-		sf := st.FieldByTag(int(tag.Tag))
 		if sf == nil {
-			fmt.Fprintf(os.Stderr, "sproto<%s>: unknown tag %d\n", st.Type.Name(), tag.Tag)
+			if err = d.unknownTag(st, elem, tag, data); err != nil {
+				return 0, d.complete(err, st.Type.Name(), int(tag.Tag), offset)
+			}
 			continue
 		}
 		v1 := elem.FieldByIndex(sf.field.Index)
-		if err = sf.dec(tag.Val, data, sf, v1); err != nil {
-			return 0, err
+		d.offset = offset
+		if err = sf.dec(d, tag.Val, data, sf, v1); err != nil {
+			return 0, d.complete(err, st.Type.Name(), sf.Tag, d.offset)
 		}
+		d.path = d.path[:len(d.path)-1]
 	}
+	d.offset = base
 	return total, nil
 }
 
+// decodeReader decodes a message of u with a MessageReader carrying d
+func decodeReader(d *decodeState, chunk []byte, u ReaderUnmarshaler) (int, error) {
+	base := d.offset
+	name := reflect.TypeOf(u).Elem().Name()
+	if d.depth++; d.opts.MaxDepth > 0 && d.depth > d.opts.MaxDepth {
+		return 0, d.complete(decodeErr(0, ErrDepthLimit), name, -1, base)
+	}
+	defer func() { d.depth-- }()
+	var r MessageReader
+	var err error
+	if len(chunk) == 0 {
+		// empty message
+		r = MessageReader{d: d, base: base, tag: -1}
+	} else if r, err = newMessageReader(d, chunk); err != nil {
+		return 0, d.complete(err, name, -1, base)
+	}
+	r.typ = reflect.TypeOf(u).Elem()
+	if err = u.UnmarshalSprotoReader(&r); err != nil {
+		return 0, d.complete(err, name, r.tag, base)
+	}
+	return r.Used(), nil
+}
+
+// DecodeOptions configures decoding, the zero value decodes the same as Decode.
+// Options apply to types implementing ReaderUnmarshaler through their
+// MessageReader, other Unmarshalers decode themselves and ignore options.
+type DecodeOptions struct {
+	// ZeroCopy makes binary fields alias data instead of copying it. They are
+	// only valid while data is alive and unmodified.
+	ZeroCopy bool
+
+	// UnsafeString makes string fields alias data as well, with the same
+	// restriction as ZeroCopy. Modifying data breaks the immutability of
+	// these strings.
+	UnsafeString bool
+
+	// UnknownTags is how to handle tags unknown to the go type, they are
+	// ignored by default.
+	UnknownTags UnknownTagPolicy
+
+	// OnUnknownTag is called for every unknown field with UnknownCallback,
+	// Data of f is only valid during the call. Returning an error stops decoding.
+	OnUnknownTag func(t reflect.Type, f UnknownField) error
+
+	// Limits against hostile data, zero means unlimited. A violation fails
+	// decoding with a DecodeError wrapping ErrDepthLimit, ErrArrayLimit,
+	// ErrBytesLimit, ErrAllocLimit or ErrFieldsLimit.
+	MaxDepth      int // nesting level of messages, the decoded message is level 1
+	MaxArrayLen   int // elements of an array or map
+	MaxBytesLen   int // length of a string or binary
+	MaxAllocBytes int // bytes allocated for decoded values, approximately
+	MaxFields     int // header fields of a message
+}
+
+type pathElem struct {
+	name  string
+	index int // index of array element, -1 if not in array
+}
+
+// decodeState carries options and the position through a decoding
+type decodeState struct {
+	opts      *DecodeOptions
+	path      []pathElem // path of the current field
+	offset    int        // offset of the current data in the decoded data
+	depth     int        // nesting level of the current message
+	allocated int        // bytes allocated, counted with MaxAllocBytes only
+
+	pathBuf [8]pathElem
+}
+
+func (d *decodeState) pathString() string {
+	var b strings.Builder
+	for i, e := range d.path {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(e.name)
+		if e.index >= 0 {
+			fmt.Fprintf(&b, "[%d]", e.index)
+		}
+	}
+	return b.String()
+}
+
+// complete fills an error with the field where it happens, offset is the
+// position that relative offset of err based on. Completed errors of nested
+// messages are returned unchanged.
+func (d *decodeState) complete(err error, typeName string, tag int, offset int) error {
+	de, ok := err.(*DecodeError)
+	if !ok {
+		de = &DecodeError{Err: err}
+	} else if de.Type != "" {
+		return err
+	}
+	de.Type = typeName
+	de.Path = d.pathString()
+	de.Tag = tag
+	de.Offset += offset
+	return de
+}
+
+func (d *decodeState) alloc(n int) error {
+	if d.opts.MaxAllocBytes > 0 {
+		if d.allocated += n; d.allocated > d.opts.MaxAllocBytes {
+			return decodeErr(0, ErrAllocLimit)
+		}
+	}
+	return nil
+}
+
+// checkArray checks an array of n elements before allocating it
+func (d *decodeState) checkArray(n int, elem reflect.Type) error {
+	return d.checkLen(n, int(elem.Size()))
+}
+
+func (d *decodeState) checkLen(n int, size int) error {
+	if d.opts.MaxArrayLen > 0 && n > d.opts.MaxArrayLen {
+		return decodeErr(0, ErrArrayLimit)
+	}
+	return d.alloc(n * size)
+}
+
+// checkElem checks the i-th element of an array whose length is unknown
+// until all elements are read
+func (d *decodeState) checkElem(i int, elem reflect.Type) error {
+	return d.checkElemSize(i, int(elem.Size()))
+}
+
+func (d *decodeState) checkElemSize(i int, size int) error {
+	if d.opts.MaxArrayLen > 0 && i >= d.opts.MaxArrayLen {
+		return decodeErr(0, ErrArrayLimit)
+	}
+	return d.alloc(size)
+}
+
+func (d *decodeState) checkBytes(data []byte, copied bool) error {
+	if d.opts.MaxBytesLen > 0 && len(data) > d.opts.MaxBytesLen {
+		return decodeErr(0, ErrBytesLimit)
+	}
+	if copied {
+		return d.alloc(len(data))
+	}
+	return nil
+}
+
+func (d *decodeState) bytes(data []byte) ([]byte, error) {
+	if err := d.checkBytes(data, !d.opts.ZeroCopy); err != nil {
+		return nil, err
+	}
+	if d.opts.ZeroCopy {
+		// limit capacity, so appending to the field never overwrites data
+		return data[:len(data):len(data)], nil
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	return buf, nil
+}
+
+func (d *decodeState) string(data []byte) (string, error) {
+	if err := d.checkBytes(data, !d.opts.UnsafeString); err != nil {
+		return "", err
+	}
+	if d.opts.UnsafeString {
+		return *(*string)(unsafe.Pointer(&data)), nil
+	}
+	return string(data), nil
+}
+
 func Decode(data []byte, sp interface{}) (used int, err error) {
+	return DecodeOptions{}.Decode(data, sp)
+}
+
+// Decode decodes data into sp with options o.
+func (o DecodeOptions) Decode(data []byte, sp interface{}) (used int, err error) {
 	defer func() {
 		if obj := recover(); obj != nil {
 			err = fmt.Errorf("sproto: Decode recovered from panic, err: %v", obj)
@@ -413,6 +713,14 @@ func Decode(data []byte, sp interface{}) (used int, err error) {
 	if err != nil {
 		return 0, err
 	}
+	d := &decodeState{opts: &o}
+	if u, ok := sp.(ReaderUnmarshaler); ok {
+		d.path = append(d.pathBuf[:0], pathElem{name: t.Elem().Name(), index: -1})
+		return decodeReader(d, data, u)
+	}
+	if u, ok := sp.(Unmarshaler); ok {
+		return u.UnmarshalSproto(data)
+	}
 	// clear sp
 	v.Elem().Set(reflect.Zero(t.Elem()))
 	if len(data) == 0 {
@@ -422,7 +730,8 @@ func Decode(data []byte, sp interface{}) (used int, err error) {
 	if err != nil {
 		return 0, err
 	}
-	return decodeMessage(data, st, v)
+	d.path = append(d.pathBuf[:0], pathElem{name: st.Type.Name(), index: -1})
+	return decodeMessage(d, data, st, v)
 }
 
 func MustDecode(data []byte, sp interface{}) int {
@@ -0,0 +1,220 @@
+package sproto
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type BlobMsg struct {
+	Name string   `sproto:"string,0"`
+	Data []byte   `sproto:"binary,1"`
+	Pics [][]byte `sproto:"binary,2,array"`
+	Tags []string `sproto:"string,3,array"`
+}
+
+var blobMsg = BlobMsg{
+	Name: "replay",
+	Data: bytes.Repeat([]byte("frame data"), 100),
+	Pics: [][]byte{bytes.Repeat([]byte("image data1"), 100), bytes.Repeat([]byte("image data2"), 100)},
+	Tags: []string{"map", "chunk"},
+}
+
+func TestDecodeCopy(t *testing.T) {
+	data := MustEncode(&blobMsg)
+	var msg BlobMsg
+	MustDecode(data, &msg)
+	for i := range data {
+		data[i] = 0
+	}
+	if !bytes.Equal(msg.Data, blobMsg.Data) || !bytes.Equal(msg.Pics[1], blobMsg.Pics[1]) || msg.Tags[0] != "map" {
+		t.Fatal("default decoding should copy data")
+	}
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	data := MustEncode(&blobMsg)
+	var msg BlobMsg
+	opts := DecodeOptions{ZeroCopy: true, UnsafeString: true}
+	used, err := opts.Decode(data, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != len(data) || !bytes.Equal(MustEncode(&msg), data) {
+		t.Fatal("zero copy decoding mismatch")
+	}
+	if cap(msg.Data) != len(msg.Data) || cap(msg.Pics[0]) != len(msg.Pics[0]) {
+		t.Fatal("aliased fields should have limited capacity")
+	}
+
+	// fields alias data
+	for i := range data {
+		data[i] = 'x'
+	}
+	if msg.Data[0] != 'x' || msg.Pics[1][0] != 'x' || msg.Name != "xxxxxx" || msg.Tags[1] != "xxxxx" {
+		t.Fatal("zero copy decoding should alias data")
+	}
+}
+
+func BenchmarkDecodeBlob(b *testing.B) {
+	b.ReportAllocs()
+	data := MustEncode(&blobMsg)
+	var msg BlobMsg
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(data, &msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeBlobZeroCopy(b *testing.B) {
+	b.ReportAllocs()
+	data := MustEncode(&blobMsg)
+	opts := DecodeOptions{ZeroCopy: true, UnsafeString: true}
+	var msg BlobMsg
+	for i := 0; i < b.N; i++ {
+		if _, err := opts.Decode(data, &msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	// corrupt the length of Bob's phone number
+	data := append([]byte{}, abData...)
+	offset := bytes.Index(data, []byte("01234567890")) - 4
+	data[offset] = 0xff
+
+	var ab AddressBook
+	_, err := Decode(data, &ab)
+	if !errors.Is(err, ErrDecode) {
+		t.Fatalf("expect ErrDecode, but get %v", err)
+	}
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("expect DecodeError, but get %v", err)
+	}
+	if de.Type != "PhoneNumber" || de.Path != "AddressBook.Person[1].Phone[0].Number" || de.Tag != 0 || de.Offset != offset {
+		t.Fatalf("unexpected error: %+v", de)
+	}
+
+	// every truncated data fails with DecodeError
+	for n := 1; n < len(abData); n++ {
+		_, err := Decode(abData[:n], &ab)
+		if err == nil {
+			continue
+		}
+		if !errors.As(err, &de) || de.Type == "" {
+			t.Fatalf("truncated at %d, unexpected error: %v", n, err)
+		}
+	}
+}
+
+func TestDecodeErrorReason(t *testing.T) {
+	cases := []struct {
+		data   []byte
+		sp     interface{}
+		reason error
+		path   string
+		offset int
+	}{
+		{
+			[]byte{0x02, 0x00},
+			&Human{},
+			errTruncatedHeader,
+			"Human",
+			0,
+		},
+		{
+			// double with 4 bytes
+			[]byte{0x02, 0x00, 0x07, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04},
+			&Data{},
+			errMalformedDouble,
+			"Data.Double",
+			10,
+		},
+		{
+			// boolean in data part
+			[]byte{0x02, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01},
+			&Human{},
+			errMalformedBool,
+			"Human.Marital",
+			10,
+		},
+	}
+	for i, c := range cases {
+		_, err := Decode(c.data, c.sp)
+		var de *DecodeError
+		if !errors.As(err, &de) || de.Err != c.reason || de.Path != c.path || de.Offset != c.offset {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	family := MustEncode(&Human{
+		Name: String("Alice"),
+		Children: []*Human{
+			{Children: []*Human{{Age: Int(1)}}},
+		},
+	})
+	data := MustEncode(&Data{
+		Numbers: []int64{1, 2, 3},
+		Number:  Int(1),
+		Strings: []string{"a", "b", "c"},
+	})
+	cases := []struct {
+		opts   DecodeOptions
+		data   []byte
+		sp     interface{}
+		reason error
+		path   string
+	}{
+		{DecodeOptions{MaxDepth: 2}, family, &Human{}, ErrDepthLimit, "Human.Children[0].Children[0]"},
+		{DecodeOptions{MaxBytesLen: 4}, family, &Human{}, ErrBytesLimit, "Human.Name"},
+		{DecodeOptions{MaxArrayLen: 2}, data, &Data{}, ErrArrayLimit, "Data.Numbers"},
+		{DecodeOptions{MaxArrayLen: 1}, abData, &AddressBook{}, ErrArrayLimit, "AddressBook.Person[0].Phone[1]"},
+		{DecodeOptions{MaxFields: 2}, data, &Data{}, ErrFieldsLimit, "Data"},
+		{DecodeOptions{MaxAllocBytes: 512}, MustEncode(&blobMsg), &BlobMsg{}, ErrAllocLimit, "BlobMsg.Data"},
+	}
+	for i, c := range cases {
+		_, err := c.opts.Decode(c.data, c.sp)
+		var de *DecodeError
+		if !errors.Is(err, c.reason) || !errors.As(err, &de) || de.Path != c.path {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+	}
+
+	opts := DecodeOptions{MaxDepth: 3, MaxArrayLen: 3, MaxBytesLen: 16, MaxAllocBytes: 1024, MaxFields: 4}
+	var book AddressBook
+	if _, err := opts.Decode(abData, &book); err != nil {
+		t.Fatal(err)
+	}
+	var human Human
+	if _, err := opts.Decode(family, &human); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type IntArrays struct {
+	Int32s []int32 `sproto:"integer,0,array"`
+	Int64s []int64 `sproto:"integer,1,array"`
+	Ints   []int   `sproto:"integer,2,array"`
+}
+
+func TestDecodeNegativeIntArray(t *testing.T) {
+	// elements fitting in 32 bits are encoded in 4 bytes
+	msg := IntArrays{
+		Int32s: []int32{-1, math.MinInt32, math.MaxInt32, 0},
+		Int64s: []int64{-150, 7},
+		Ints:   []int{-2, 3},
+	}
+	data := MustEncode(&msg)
+	var decoded IntArrays
+	MustDecode(data, &decoded)
+	if !reflect.DeepEqual(&decoded, &msg) {
+		t.Fatalf("unexpected decoded: %+v", decoded)
+	}
+}
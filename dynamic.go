@@ -0,0 +1,589 @@
+package sproto
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/xjdrew/gosproto/schema"
+)
+
+// MessageDescriptor describes a message type at runtime, it drives DynamicMessage.
+// A descriptor can also be a literal with Fields, which shouldn't change once
+// it's used but by AddField.
+type MessageDescriptor struct {
+	Name   string
+	Fields []*FieldDescriptor // ordered by tag
+
+	indexOnce sync.Once // builds maps of Fields on first use
+	tagMap    map[int]*FieldDescriptor
+	nameMap   map[string]*FieldDescriptor
+}
+
+// FieldDescriptor describes a field of MessageDescriptor, it has the same
+// meaning as the sproto struct tag of a go field.
+type FieldDescriptor struct {
+	Name     string
+	Wire     string
+	Tag      int
+	Array    bool
+	Message  *MessageDescriptor // element type of struct fields
+	Decimal  int                // n of integer(n), values are float64
+	Map      bool               // array of struct as a map keyed by the key field of elements
+	KeyTag   int                // tag of the key field if Map
+	MapValue bool               // map values are the value field of elements instead of elements
+	ValueTag int                // tag of the value field if MapValue
+}
+
+// NewMessageDescriptor creates a message descriptor without fields.
+func NewMessageDescriptor(name string) *MessageDescriptor {
+	return &MessageDescriptor{Name: name}
+}
+
+func (md *MessageDescriptor) index() {
+	md.indexOnce.Do(func() {
+		md.tagMap = make(map[int]*FieldDescriptor, len(md.Fields))
+		md.nameMap = make(map[string]*FieldDescriptor, len(md.Fields))
+		for _, fd := range md.Fields {
+			md.tagMap[fd.Tag] = fd
+			md.nameMap[fd.Name] = fd
+		}
+	})
+}
+
+// AddField adds a field to md. Message of a struct field may be added fields
+// later, so that types can refer to each other.
+func (md *MessageDescriptor) AddField(fd *FieldDescriptor) error {
+	switch fd.Wire {
+	case WireVarintName, WireBooleanName, WireStringName, WireBytesName, WireDoubleName:
+		if fd.Message != nil {
+			return fmt.Errorf("sproto: field(%s.%s) of %s has message type", md.Name, fd.Name, fd.Wire)
+		}
+	case WireStructName:
+		if fd.Message == nil {
+			return fmt.Errorf("sproto: field(%s.%s) has no message type", md.Name, fd.Name)
+		}
+	default:
+		return fmt.Errorf("sproto: field(%s.%s) unknown wire type: %s", md.Name, fd.Name, fd.Wire)
+	}
+	if fd.Tag < TagMin || fd.Tag > TagMax {
+		return fmt.Errorf("sproto: field(%s.%s) tag(%d) overflow", md.Name, fd.Name, fd.Tag)
+	}
+	if fd.Decimal < 0 || fd.Decimal > MaxDecimal || (fd.Decimal > 0 && fd.Wire != WireVarintName) {
+		return fmt.Errorf("sproto: field(%s.%s) illegal decimal(%d)", md.Name, fd.Name, fd.Decimal)
+	}
+	if fd.Map && (!fd.Array || fd.Wire != WireStructName) {
+		return fmt.Errorf("sproto: field(%s.%s) Map depends on array of struct", md.Name, fd.Name)
+	}
+	if fd.MapValue && !fd.Map {
+		return fmt.Errorf("sproto: field(%s.%s) MapValue depends on Map", md.Name, fd.Name)
+	}
+	md.index()
+	if _, ok := md.tagMap[fd.Tag]; ok {
+		return fmt.Errorf("sproto: field(%s.%s) tag repeated", md.Name, fd.Name)
+	}
+	if _, ok := md.nameMap[fd.Name]; ok {
+		return fmt.Errorf("sproto: field(%s.%s) name repeated", md.Name, fd.Name)
+	}
+
+	md.tagMap[fd.Tag] = fd
+	md.nameMap[fd.Name] = fd
+	md.Fields = append(md.Fields, fd)
+	sort.Slice(md.Fields, func(i, j int) bool {
+		return md.Fields[i].Tag < md.Fields[j].Tag
+	})
+	return nil
+}
+
+func (md *MessageDescriptor) FieldByTag(tag int) *FieldDescriptor {
+	md.index()
+	return md.tagMap[tag]
+}
+
+func (md *MessageDescriptor) FieldByName(name string) *FieldDescriptor {
+	md.index()
+	return md.nameMap[name]
+}
+
+// key and value fields of a map element
+func (fd *FieldDescriptor) mapFields() (key, value *FieldDescriptor, err error) {
+	if key = fd.Message.FieldByTag(fd.KeyTag); key == nil {
+		err = fmt.Errorf("sproto: field(%s) key type(%s) no tag(%d)", fd.Name, fd.Message.Name, fd.KeyTag)
+		return
+	}
+	if key.Array || key.Decimal > 0 || (key.Wire != WireVarintName && key.Wire != WireStringName) {
+		err = fmt.Errorf("sproto: field(%s) illegal key type(%s), map key must be integer or string", fd.Name, key.Wire)
+		return
+	}
+	if fd.MapValue {
+		if value = fd.Message.FieldByTag(fd.ValueTag); value == nil {
+			err = fmt.Errorf("sproto: field(%s) value type(%s) no tag(%d)", fd.Name, fd.Message.Name, fd.ValueTag)
+			return
+		}
+	}
+	return
+}
+
+// DescriptorsFromSchema creates descriptors of all types in s, including the
+// inline request and response types of protocols. Descriptors are keyed by
+// full type name, e.g. "Person.PhoneNumber" or "foobar.request".
+func DescriptorsFromSchema(s *schema.Schema) (map[string]*MessageDescriptor, error) {
+	// s.Types lacks the inline protocol types, which are collected with
+	// their nested types
+	var types []*schema.Type
+	seen := make(map[*schema.Type]bool)
+	var collect func(t *schema.Type)
+	collect = func(t *schema.Type) {
+		if t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		types = append(types, t)
+		for _, nested := range t.Types {
+			collect(nested)
+		}
+	}
+	for _, t := range s.Types {
+		collect(t)
+	}
+	for _, p := range s.Protocols {
+		collect(p.Request)
+		collect(p.Response)
+	}
+
+	mds := make(map[string]*MessageDescriptor)
+	for _, t := range types {
+		mds[t.Name] = NewMessageDescriptor(t.Name)
+	}
+	for _, t := range types {
+		md := mds[t.Name]
+		for _, f := range t.Fields {
+			fd := &FieldDescriptor{
+				Name:    f.Name,
+				Wire:    f.TypeName,
+				Tag:     f.Tag,
+				Array:   f.Array,
+				Decimal: f.Decimal,
+			}
+			if f.Type != nil {
+				fd.Wire = WireStructName
+				fd.Message = mds[f.Type.Name]
+			}
+			if f.Key != nil {
+				fd.Map, fd.KeyTag = true, f.Key.Tag
+			}
+			if f.Value != nil {
+				fd.MapValue, fd.ValueTag = true, f.Value.Tag
+			}
+			if err := md.AddField(fd); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return mds, nil
+}
+
+// DynamicMessage is a message whose type is only known at runtime. It
+// implements Marshaler and Unmarshaler, so it's encoded and decoded by Encode
+// and Decode as well, byte-identical to a go struct of the same layout.
+//
+// Field values have these go types:
+//
+//	integer: int64, or float64 if Decimal is set
+//	boolean: bool
+//	string:  string
+//	binary:  []byte
+//	double:  float64
+//	struct:  *DynamicMessage
+//	arrays:  []int64 or []float64, []bool, []string, [][]byte, []float64, []*DynamicMessage
+//	maps:    map[interface{}]interface{}, keyed by int64 or string; values are
+//	         *DynamicMessage, or values of the value field if MapValue is set
+type DynamicMessage struct {
+	desc   *MessageDescriptor
+	values map[int]interface{}
+}
+
+func NewDynamicMessage(md *MessageDescriptor) *DynamicMessage {
+	return &DynamicMessage{
+		desc:   md,
+		values: make(map[int]interface{}),
+	}
+}
+
+func (m *DynamicMessage) Descriptor() *MessageDescriptor {
+	return m.desc
+}
+
+// Get returns the value of field name, ok is false if the field isn't set.
+func (m *DynamicMessage) Get(name string) (v interface{}, ok bool) {
+	if fd := m.desc.FieldByName(name); fd != nil {
+		v, ok = m.values[fd.Tag]
+	}
+	return
+}
+
+// GetByTag returns the value of field tag, ok is false if the field isn't set.
+func (m *DynamicMessage) GetByTag(tag int) (v interface{}, ok bool) {
+	v, ok = m.values[tag]
+	return
+}
+
+// Set sets the value of field name, a nil value clears the field.
+func (m *DynamicMessage) Set(name string, v interface{}) error {
+	fd := m.desc.FieldByName(name)
+	if fd == nil {
+		return fmt.Errorf("sproto: %s has no field %s", m.desc.Name, name)
+	}
+	return m.set(fd, v)
+}
+
+// SetByTag sets the value of field tag, a nil value clears the field.
+func (m *DynamicMessage) SetByTag(tag int, v interface{}) error {
+	fd := m.desc.FieldByTag(tag)
+	if fd == nil {
+		return fmt.Errorf("sproto: %s has no tag %d", m.desc.Name, tag)
+	}
+	return m.set(fd, v)
+}
+
+func (m *DynamicMessage) set(fd *FieldDescriptor, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || isNilValue(rv) {
+		delete(m.values, fd.Tag)
+		return nil
+	}
+	v, err := fd.normalize(v)
+	if err != nil {
+		return err
+	}
+	m.values[fd.Tag] = v
+	return nil
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+func (fd *FieldDescriptor) typeError(v interface{}) error {
+	kind := fd.Wire
+	if fd.Array {
+		kind = "array of " + kind
+	}
+	return fmt.Errorf("sproto: field(%s) of %s can't be set to %T", fd.Name, kind, v)
+}
+
+// normalizeElem checks that v fits a non-array field and converts integers to int64
+func (fd *FieldDescriptor) normalizeElem(v interface{}) (interface{}, error) {
+	switch {
+	case fd.Decimal > 0:
+		if d, ok := v.(float64); ok {
+			return d, nil
+		}
+		return nil, fd.typeError(v)
+	}
+	switch fd.Wire {
+	case WireVarintName:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+			return rv.Int(), nil
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+			return int64(rv.Uint()), nil
+		}
+	case WireBooleanName:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case WireStringName:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case WireBytesName:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+	case WireDoubleName:
+		if d, ok := v.(float64); ok {
+			return d, nil
+		}
+	case WireStructName:
+		if sub, ok := v.(*DynamicMessage); ok && sub.desc == fd.Message {
+			return sub, nil
+		}
+	}
+	return nil, fd.typeError(v)
+}
+
+func (fd *FieldDescriptor) normalize(v interface{}) (interface{}, error) {
+	if !fd.Array {
+		return fd.normalizeElem(v)
+	}
+	if fd.Map {
+		return fd.normalizeMap(v)
+	}
+	switch fd.Wire {
+	case WireVarintName:
+		if fd.Decimal > 0 {
+			if vals, ok := v.([]float64); ok {
+				return vals, nil
+			}
+			break
+		}
+		switch vals := v.(type) {
+		case []int64:
+			return vals, nil
+		case []int:
+			ints := make([]int64, len(vals))
+			for i, n := range vals {
+				ints[i] = int64(n)
+			}
+			return ints, nil
+		}
+	case WireBooleanName:
+		if vals, ok := v.([]bool); ok {
+			return vals, nil
+		}
+	case WireStringName:
+		if vals, ok := v.([]string); ok {
+			return vals, nil
+		}
+	case WireBytesName:
+		if vals, ok := v.([][]byte); ok {
+			return vals, nil
+		}
+	case WireDoubleName:
+		if vals, ok := v.([]float64); ok {
+			return vals, nil
+		}
+	case WireStructName:
+		if vals, ok := v.([]*DynamicMessage); ok {
+			for _, sub := range vals {
+				if sub != nil && sub.desc != fd.Message {
+					return nil, fd.typeError(sub)
+				}
+			}
+			return vals, nil
+		}
+	}
+	return nil, fd.typeError(v)
+}
+
+func (fd *FieldDescriptor) normalizeMap(v interface{}) (interface{}, error) {
+	key, value, err := fd.mapFields()
+	if err != nil {
+		return nil, err
+	}
+	vals, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fd.typeError(v)
+	}
+	m := make(map[interface{}]interface{}, len(vals))
+	for k, val := range vals {
+		if k, err = key.normalizeElem(k); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			if sub, ok := val.(*DynamicMessage); !ok || sub == nil || sub.desc != fd.Message {
+				return nil, fd.typeError(val)
+			}
+		} else if val != nil {
+			if val, err = value.normalize(val); err != nil {
+				return nil, err
+			}
+		}
+		m[k] = val
+	}
+	return m, nil
+}
+
+// MarshalSproto implements Marshaler.
+func (m *DynamicMessage) MarshalSproto(dst []byte) ([]byte, error) {
+	if m == nil {
+		w := NewMessageWriter(dst, 0)
+		return w.Bytes(), nil
+	}
+	w := NewMessageWriter(dst, len(m.desc.Fields))
+	for _, fd := range m.desc.Fields {
+		v, ok := m.values[fd.Tag]
+		if !ok {
+			continue
+		}
+		if err := fd.write(&w, v); err != nil {
+			return dst, err
+		}
+	}
+	return w.Bytes(), nil
+}
+
+func (fd *FieldDescriptor) write(w *MessageWriter, v interface{}) error {
+	switch v := v.(type) {
+	case int64:
+		w.WriteInt(fd.Tag, v)
+	case bool:
+		w.WriteBool(fd.Tag, v)
+	case string:
+		w.WriteString(fd.Tag, v)
+	case []byte:
+		w.WriteBytes(fd.Tag, v)
+	case float64:
+		if fd.Decimal > 0 {
+			return w.WriteDecimal(fd.Tag, v, fd.Decimal)
+		}
+		w.WriteDouble(fd.Tag, v)
+	case *DynamicMessage:
+		return w.WriteStruct(fd.Tag, v)
+	case []int64:
+		w.WriteInt64Slice(fd.Tag, v)
+	case []bool:
+		w.WriteBoolSlice(fd.Tag, v)
+	case []string:
+		w.WriteStringSlice(fd.Tag, v)
+	case [][]byte:
+		w.WriteBytesSlice(fd.Tag, v)
+	case []float64:
+		if fd.Decimal > 0 {
+			return w.WriteDecimalSlice(fd.Tag, v, fd.Decimal)
+		}
+		w.WriteDoubleSlice(fd.Tag, v)
+	case []*DynamicMessage:
+		offset := w.BeginArray(fd.Tag)
+		for _, sub := range v {
+			if err := w.WriteElem(sub); err != nil {
+				return err
+			}
+		}
+		w.EndArray(offset)
+	case map[interface{}]interface{}:
+		offset := w.BeginArray(fd.Tag)
+		for key, val := range v {
+			var elem *DynamicMessage
+			if !fd.MapValue {
+				elem = val.(*DynamicMessage)
+			} else {
+				// construct map element by key and value
+				elem = NewDynamicMessage(fd.Message)
+				elem.values[fd.KeyTag] = key
+				if val != nil {
+					elem.values[fd.ValueTag] = val
+				}
+			}
+			if err := w.WriteElem(elem); err != nil {
+				return err
+			}
+		}
+		w.EndArray(offset)
+	default:
+		return fd.typeError(v)
+	}
+	return nil
+}
+
+// UnmarshalSproto implements Unmarshaler.
+func (m *DynamicMessage) UnmarshalSproto(data []byte) (int, error) {
+	return Decode(data, m)
+}
+
+// UnmarshalSprotoReader implements ReaderUnmarshaler, unknown tags are handled
+// by MessageReader.SkipUnknown.
+func (m *DynamicMessage) UnmarshalSprotoReader(r *MessageReader) error {
+	m.values = make(map[int]interface{})
+	for r.Next() {
+		fd := m.desc.FieldByTag(r.Tag())
+		if fd == nil {
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+			continue
+		}
+		v, err := fd.read(r)
+		if err != nil {
+			return err
+		}
+		m.values[fd.Tag] = v
+	}
+	return r.Err()
+}
+
+func (fd *FieldDescriptor) read(r *MessageReader) (interface{}, error) {
+	if !fd.Array {
+		switch fd.Wire {
+		case WireVarintName:
+			if fd.Decimal > 0 {
+				return r.ReadDecimal(fd.Decimal)
+			}
+			return r.ReadInt()
+		case WireBooleanName:
+			return r.ReadBool()
+		case WireStringName:
+			return r.ReadString()
+		case WireBytesName:
+			return r.ReadBytes()
+		case WireDoubleName:
+			return r.ReadDouble()
+		default:
+			sub := NewDynamicMessage(fd.Message)
+			if err := r.ReadStruct(sub); err != nil {
+				return nil, err
+			}
+			return sub, nil
+		}
+	}
+
+	switch fd.Wire {
+	case WireVarintName:
+		if fd.Decimal > 0 {
+			return r.ReadDecimalSlice(fd.Decimal)
+		}
+		return r.ReadInt64Slice()
+	case WireBooleanName:
+		return r.ReadBoolSlice()
+	case WireStringName:
+		return r.ReadStringSlice()
+	case WireBytesName:
+		return r.ReadBytesSlice()
+	case WireDoubleName:
+		return r.ReadDoubleSlice()
+	}
+
+	arr, err := r.ReadArray()
+	if err != nil {
+		return nil, err
+	}
+	if !fd.Map {
+		vals := make([]*DynamicMessage, 0, 16)
+		for arr.Next() {
+			sub := NewDynamicMessage(fd.Message)
+			if err := arr.ReadStruct(sub); err != nil {
+				return nil, err
+			}
+			vals = append(vals, sub)
+		}
+		return vals, arr.Err()
+	}
+
+	if _, _, err = fd.mapFields(); err != nil {
+		return nil, err
+	}
+	m := make(map[interface{}]interface{})
+	for arr.Next() {
+		sub := NewDynamicMessage(fd.Message)
+		if err := arr.ReadStruct(sub); err != nil {
+			return nil, err
+		}
+		key, ok := sub.values[fd.KeyTag]
+		if !ok {
+			return nil, ErrNilMapKey
+		}
+		if !fd.MapValue {
+			m[key] = sub
+		} else {
+			m[key] = sub.values[fd.ValueTag]
+		}
+	}
+	return m, arr.Err()
+}
@@ -0,0 +1,365 @@
+package sproto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/xjdrew/gosproto/schema"
+)
+
+const dynamicSchema = `
+.PhoneNumber {
+	Number 0 : string
+	Type 1 : integer
+}
+
+.Person {
+	Name 0 : string
+	Id 1 : integer
+	Email 2 : string
+	Phone 3 : *PhoneNumber
+}
+
+.AddressBook {
+	Person 0 : *Person
+}
+
+.Human {
+	Name 0 : string
+	Age 1 : integer
+	Marital 2 : boolean
+	Children 3 : *Human
+}
+
+.Data {
+	Numbers 0 : *integer
+	Bools 1 : *boolean
+	Number 2 : integer
+	BigNumber 3 : integer
+	Double 4 : double
+	Doubles 5 : *double
+	Strings 7 : *string
+	Bytes 8 : binary
+}
+
+.Decimal {
+	Price 0 : integer(2)
+	Rate 1 : integer(4)
+	Prices 2 : *integer(2)
+}
+
+.NestData {
+	A 1 : string
+	B 3 : boolean
+	C 5 : integer
+	D 6 : double
+}
+
+.SimpleMapItem {
+	K 1 : integer
+	V 2 : string
+}
+
+.StructMapItem {
+	Key 3 : integer
+	Value 5 : NestData
+}
+
+.MapMsg {
+	SimpleMap 0 : *SimpleMapItem()
+	SimpleMapPtr 1 : *SimpleMapItem()
+	StructMap 2 : *StructMapItem()
+	MainIndexMap 3 : *NestData(A)
+}
+
+foobar 1 {
+	request {
+		what 0 : string
+	}
+}
+`
+
+func dynamicDescriptors(t *testing.T) map[string]*MessageDescriptor {
+	f, err := schema.Parse("dynamic.sproto", []byte(dynamicSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := schema.Resolve(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mds, err := DescriptorsFromSchema(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mds
+}
+
+func TestDynamicDecodeEncode(t *testing.T) {
+	mds := dynamicDescriptors(t)
+	for _, tc := range testCases {
+		md := mds[reflect.TypeOf(tc.Struct).Elem().Name()]
+		m := NewDynamicMessage(md)
+		used, err := Decode(tc.Data, m)
+		if err != nil {
+			t.Fatalf("test case *%s* failed with error:%s", tc.Name, err)
+		}
+		if used != len(tc.Data) {
+			t.Fatalf("test case *%s* failed: data length mismatch", tc.Name)
+		}
+
+		output, err := Encode(m)
+		if err != nil {
+			t.Fatalf("test case *%s* failed with error:%s", tc.Name, err)
+		}
+		if !bytes.Equal(output, tc.Data) {
+			t.Log("encoded:", output)
+			t.Log("expected:", tc.Data)
+			t.Fatalf("test case %s failed", tc.Name)
+		}
+	}
+}
+
+func TestDynamicSetGet(t *testing.T) {
+	mds := dynamicDescriptors(t)
+	human := mds["Human"]
+
+	alice := NewDynamicMessage(human)
+	if err := alice.Set("Name", "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SetByTag(1, 13); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Set("Marital", false); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := alice.Get("Age"); !ok || v != int64(13) {
+		t.Fatalf("unexpected age: %v", v)
+	}
+	if _, ok := alice.Get("Children"); ok {
+		t.Fatal("children should be unset")
+	}
+
+	output, err := Encode(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, testCases[0].Data) {
+		t.Log("encoded:", output)
+		t.Log("expected:", testCases[0].Data)
+		t.Fatal("encode dynamic message failed")
+	}
+
+	// setting nil clears the field
+	alice.Set("Marital", nil)
+	if _, ok := alice.Get("Marital"); ok {
+		t.Fatal("marital should be cleared")
+	}
+
+	bad := []struct {
+		name string
+		v    interface{}
+	}{
+		{"Name", 1},
+		{"Age", "13"},
+		{"Marital", 1},
+		{"Children", alice},
+		{"Children", []*DynamicMessage{NewDynamicMessage(mds["Data"])}},
+		{"Unknown", 1},
+	}
+	for _, c := range bad {
+		if err := alice.Set(c.name, c.v); err == nil {
+			t.Fatalf("set %s to %T should fail", c.name, c.v)
+		}
+	}
+}
+
+func TestDynamicMap(t *testing.T) {
+	mds := dynamicDescriptors(t)
+	data, err := Encode(&mapMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewDynamicMessage(mds["MapMsg"])
+	if _, err := Decode(data, m); err != nil {
+		t.Fatal(err)
+	}
+	simple, _ := m.Get("SimpleMap")
+	expected := map[interface{}]interface{}{int64(1): "v1", int64(2): "v2"}
+	if !reflect.DeepEqual(simple, expected) {
+		t.Fatalf("unexpected simple map: %v", simple)
+	}
+	mainIndex, _ := m.Get("MainIndexMap")
+	item := mainIndex.(map[interface{}]interface{})["11va"].(*DynamicMessage)
+	if v, _ := item.Get("C"); v != int64(123) {
+		t.Fatalf("unexpected main index item: %v", item.values)
+	}
+
+	output, err := Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg MapMsg
+	if _, err := Decode(output, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, mapMsg) {
+		t.Fatalf("map message mismatch: %v", msg)
+	}
+
+	// set map with int keys
+	if err := m.Set("SimpleMap", map[interface{}]interface{}{3: "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("SimpleMap", map[interface{}]interface{}{"3": "v3"}); err == nil {
+		t.Fatal("string key of integer map should fail")
+	}
+}
+
+func TestDynamicDescriptor(t *testing.T) {
+	mds := dynamicDescriptors(t)
+	if md := mds["foobar.request"]; md == nil || md.FieldByName("what") == nil {
+		t.Fatal("inline protocol type missing")
+	}
+
+	// recursive type built by hand
+	node := NewMessageDescriptor("Node")
+	fields := []*FieldDescriptor{
+		{Name: "children", Wire: WireStructName, Tag: 1, Array: true, Message: node},
+		{Name: "id", Wire: WireVarintName, Tag: 0},
+	}
+	for _, fd := range fields {
+		if err := node.AddField(fd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if node.Fields[0].Name != "id" {
+		t.Fatal("fields should be ordered by tag")
+	}
+	if err := node.AddField(&FieldDescriptor{Name: "dup", Wire: WireVarintName, Tag: 0}); err == nil {
+		t.Fatal("repeated tag should fail")
+	}
+
+	root := NewDynamicMessage(node)
+	child := NewDynamicMessage(node)
+	child.Set("id", 2)
+	root.Set("id", 1)
+	root.Set("children", []*DynamicMessage{child, nil})
+
+	data, err := Encode(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewDynamicMessage(node)
+	if _, err := Decode(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := decoded.Get("children")
+	children := v.([]*DynamicMessage)
+	if len(children) != 2 {
+		t.Fatalf("unexpected children: %v", children)
+	}
+	if id, _ := children[0].Get("id"); id != int64(2) {
+		t.Fatalf("unexpected child id: %v", id)
+	}
+	if _, ok := children[1].Get("id"); ok {
+		t.Fatal("nil child should decode as empty message")
+	}
+}
+
+// descriptors built from zero values, a map keyed by tag 0
+func TestDynamicDescriptorZeroValue(t *testing.T) {
+	item := NewMessageDescriptor("Item")
+	var id, name FieldDescriptor
+	id.Name, id.Wire = "id", WireVarintName
+	name.Name, name.Wire, name.Tag = "name", WireStringName, 1
+	for _, fd := range []*FieldDescriptor{&id, &name} {
+		if err := item.AddField(fd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bag := NewMessageDescriptor("Bag")
+	var items, names FieldDescriptor
+	items.Name, items.Wire, items.Array, items.Message, items.Map = "items", WireStructName, true, item, true
+	names.Name, names.Wire, names.Tag, names.Array, names.Message = "names", WireStructName, 1, true, item
+	names.Map, names.MapValue, names.ValueTag = true, true, 1
+	for _, fd := range []*FieldDescriptor{&items, &names} {
+		if err := bag.AddField(fd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bag.AddField(&FieldDescriptor{Name: "bad", Wire: WireVarintName, Tag: 2, Map: true}); err == nil {
+		t.Fatal("map of integer should fail")
+	}
+
+	elem := NewDynamicMessage(item)
+	elem.Set("id", 7)
+	elem.Set("name", "sword")
+	msg := NewDynamicMessage(bag)
+	if err := msg.Set("items", map[interface{}]interface{}{int64(7): elem}); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Set("names", map[interface{}]interface{}{int64(7): "sword"}); err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewDynamicMessage(bag)
+	MustDecode(MustEncode(msg), decoded)
+	v, _ := decoded.Get("items")
+	if e := v.(map[interface{}]interface{})[int64(7)]; e == nil {
+		t.Fatalf("unexpected items: %v", v)
+	}
+	v, _ = decoded.Get("names")
+	if n := v.(map[interface{}]interface{})[int64(7)]; n != "sword" {
+		t.Fatalf("unexpected names: %v", v)
+	}
+}
+
+func TestDynamicDescriptorLiteral(t *testing.T) {
+	phone := &MessageDescriptor{
+		Name: "PhoneNumber",
+		Fields: []*FieldDescriptor{
+			{Name: "Number", Wire: WireStringName},
+			{Name: "Type", Wire: WireVarintName, Tag: 1},
+		},
+	}
+	m := NewDynamicMessage(phone)
+	if err := m.Set("Number", "10086"); err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewDynamicMessage(phone)
+	MustDecode(MustEncode(m), decoded)
+	if v, _ := decoded.Get("Number"); v != "10086" {
+		t.Fatalf("unexpected number: %v", v)
+	}
+
+	// zero value
+	empty := &MessageDescriptor{Name: "Empty"}
+	if err := empty.AddField(&FieldDescriptor{Name: "id", Wire: WireVarintName}); err != nil {
+		t.Fatal(err)
+	}
+	if empty.FieldByName("id") == nil || empty.FieldByTag(0) == nil {
+		t.Fatal("field isn't added")
+	}
+}
+
+func TestDynamicDecimal(t *testing.T) {
+	mds := dynamicDescriptors(t)
+	msg := &DecimalMsg{Price: Double(12.34), Rate: 0.0325, Prices: []float64{0.29, -1.5}}
+	data := MustEncode(msg)
+
+	m := NewDynamicMessage(mds["Decimal"])
+	MustDecode(data, m)
+	if v, _ := m.Get("Price"); v != 12.34 {
+		t.Fatalf("unexpected price: %v", v)
+	}
+	if !bytes.Equal(MustEncode(m), data) {
+		t.Fatal("encode dynamic decimal failed")
+	}
+	if err := m.Set("Rate", 1); err == nil {
+		t.Fatal("set decimal to int should fail")
+	}
+}
@@ -2,6 +2,7 @@ package sproto
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"reflect"
 	"unsafe"
@@ -111,7 +112,7 @@ func extractInt(v reflect.Value) (n uint64, sz int) {
 		n = v.Uint()
 		sz = 4
 	default: //case reflect.Uint32, reflect.Uint64, reflect.Uint:
-		n := v.Uint()
+		n = v.Uint()
 		if n <= MaxInt32 {
 			sz = 4
 		} else {
@@ -121,160 +122,107 @@ func extractInt(v reflect.Value) (n uint64, sz int) {
 	return
 }
 
-func encodeInt(sf *SprotoField, v reflect.Value) []byte {
-	n, sz := extractInt(v.Elem())
-	if n <= MaxEmbeddedInt {
-		return nil
-	}
+// encoders append the data part of a field to dst, they are only called for
+// fields whose value isn't embedded in header
 
-	buf := make([]byte, sz)
+func encodeInt(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	n, sz := extractInt(v.Elem())
 	if sz == 4 {
-		writeUint32(buf, uint32(n))
-	} else {
-		writeUint64(buf, n)
+		return appendUint32(dst, uint32(n))
 	}
-	return buf
+	return appendUint64(dst, n)
 }
 
-func encodeDouble(sf *SprotoField, v reflect.Value) []byte {
-	n := math.Float64bits(v.Elem().Float())
-	buf := make([]byte, DOUBLE_SZ)
-	writeUint64(buf, n)
-	return buf
+func encodeDouble(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	return appendUint64(dst, math.Float64bits(v.Elem().Float()))
 }
 
-func encodeString(sf *SprotoField, v reflect.Value) []byte {
-	str := v.Elem().String()
-	buf := make([]byte, len(str))
-	copy(buf, str)
-	return buf
+func encodeString(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	return append(dst, v.Elem().String()...)
 }
 
-func encodeBytes(sf *SprotoField, v reflect.Value) []byte {
-	bytes := v.Bytes()
-	buf := make([]byte, len(bytes))
-	copy(buf, bytes)
-	return buf
+func encodeBytes(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	return append(dst, v.Bytes()...)
 }
 
-func encodeStruct(sf *SprotoField, v reflect.Value) []byte {
-	return encodeMessage(sf.st, v)
+func encodeStruct(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	return encodeMessage(dst, sf.st, v)
 }
 
-func encodeBoolSlice(sf *SprotoField, v reflect.Value) []byte {
-	sz := v.Len()
-	buf := make([]byte, sz)
-	offset := 0
-	for i := 0; i < sz; i++ {
+func encodeBoolSlice(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	for i := 0; i < v.Len(); i++ {
 		if v.Index(i).Bool() {
-			buf[offset+i] = 1
+			dst = append(dst, 1)
 		} else {
-			buf[offset+i] = 0
+			dst = append(dst, 0)
 		}
 	}
-	return buf
+	return dst
 }
 
-func encodeBytesSlice(sf *SprotoField, v reflect.Value) []byte {
-	var sz int
-	for i := 0; i < v.Len(); i++ {
-		bs := v.Index(i).Bytes()
-		sz += 4 + len(bs)
-	}
-	buf := make([]byte, sz)
-	offset := 0
+func encodeBytesSlice(sf *SprotoField, dst []byte, v reflect.Value) []byte {
 	for i := 0; i < v.Len(); i++ {
 		bs := v.Index(i).Bytes()
-		strLen := len(bs)
-		writeUint32(buf[offset:], uint32(strLen))
-		copy(buf[offset+4:], bs)
-		offset += 4 + strLen
+		dst = appendUint32(dst, uint32(len(bs)))
+		dst = append(dst, bs...)
 	}
-	return buf
+	return dst
 }
 
-func encodeStringSlice(sf *SprotoField, v reflect.Value) []byte {
-	var sz int
+func encodeStringSlice(sf *SprotoField, dst []byte, v reflect.Value) []byte {
 	for i := 0; i < v.Len(); i++ {
 		str := v.Index(i).String()
-		sz += 4 + len(str)
+		dst = appendUint32(dst, uint32(len(str)))
+		dst = append(dst, str...)
 	}
-	buf := make([]byte, sz)
-	offset := 0
-	for i := 0; i < v.Len(); i++ {
-		str := v.Index(i).String()
-		strLen := len(str)
-		writeUint32(buf[offset:], uint32(strLen))
-		copy(buf[offset+4:], str)
-		offset += 4 + strLen
-	}
-	return buf
+	return dst
 }
 
-func encodeIntSlice(sf *SprotoField, v reflect.Value) []byte {
+func encodeIntSlice(sf *SprotoField, dst []byte, v reflect.Value) []byte {
 	sz := v.Len()
 	if sz == 0 {
-		return []byte{}
+		return dst
 	}
 
-	vals := make([]uint64, v.Len())
 	var intLen int = 4 // could be 4 and 8
-	for i := 0; i < v.Len(); i++ {
-		n, tmp := extractInt(v.Index(i))
-		if tmp > intLen {
+	for i := 0; i < sz; i++ {
+		if _, tmp := extractInt(v.Index(i)); tmp > intLen {
 			intLen = tmp
 		}
-		vals[i] = n
 	}
 
-	buf := make([]byte, 1+intLen*v.Len())
-	buf[0] = uint8(intLen) // put intLen
-	offset := 1
-	for _, val := range vals {
+	dst = append(dst, uint8(intLen)) // put intLen
+	for i := 0; i < sz; i++ {
+		n, _ := extractInt(v.Index(i))
 		if intLen == 4 {
-			writeUint32(buf[offset:], uint32(val))
+			dst = appendUint32(dst, uint32(n))
 		} else {
-			writeUint64(buf[offset:], val)
+			dst = appendUint64(dst, n)
 		}
-		offset += intLen
 	}
-	return buf
+	return dst
 }
 
-func encodeDoubleSlice(sf *SprotoField, v reflect.Value) []byte {
-	buf := make([]byte, 1+DOUBLE_SZ*v.Len())
-	buf[0] = uint8(DOUBLE_SZ)
-	offset := 1
+func encodeDoubleSlice(sf *SprotoField, dst []byte, v reflect.Value) []byte {
+	dst = append(dst, uint8(DOUBLE_SZ))
 	for i := 0; i < v.Len(); i++ {
-		dv := v.Index(i).Float()
-		writeUint64(buf[offset:], math.Float64bits(dv))
-		offset += DOUBLE_SZ
+		dst = appendUint64(dst, math.Float64bits(v.Index(i).Float()))
 	}
-	return buf
+	return dst
 }
 
-func encodeStructSlice(sf *SprotoField, v reflect.Value) []byte {
-	sz := 0
-	vals := make([][]byte, v.Len())
+func encodeStructSlice(sf *SprotoField, dst []byte, v reflect.Value) []byte {
 	for i := 0; i < v.Len(); i++ {
-		val := encodeMessage(sf.st, v.Index(i))
-		vals[i] = val
-		sz += len(val) + 4
+		offset := len(dst)
+		dst = append(dst, 0, 0, 0, 0)
+		dst = encodeMessage(dst, sf.st, v.Index(i))
+		writeUint32(dst[offset:], uint32(len(dst)-offset-4))
 	}
-
-	buf := make([]byte, sz)
-	offset := 0
-	for _, val := range vals {
-		valLen := len(val)
-		writeUint32(buf[offset:], uint32(valLen))
-		copy(buf[offset+4:], val)
-		offset += valLen + 4
-	}
-	return buf
+	return dst
 }
 
 // v is a map
-func encodeMap(sf *SprotoField, v reflect.Value) []byte {
+func encodeMap(sf *SprotoField, dst []byte, v reflect.Value) []byte {
 	st := sf.st
 
 	// map convert to slice
@@ -297,7 +245,7 @@ func encodeMap(sf *SprotoField, v reflect.Value) []byte {
 			vals = reflect.Append(vals, val)
 		}
 	}
-	return encodeStructSlice(sf, vals)
+	return encodeStructSlice(sf, dst, vals)
 }
 
 func skipTag(tag, nextTag int) uint16 {
@@ -308,77 +256,116 @@ func skipTag(tag, nextTag int) uint16 {
 	return 0
 }
 
-func encodeHeaders(headers []uint16, reserved int) []byte {
-	buf := make([]byte, (len(headers)+1)*2+reserved)
-	i := 0
-	writeUint16(buf, uint16(len(headers)))
-	i = i + 2
-	for _, v := range headers {
-		writeUint16(buf[i:], v)
-		i = i + 2
+// encodeMessage appends the message to dst, headers are written in the space
+// reserved by MessageWriter and data is appended right after them.
+func encodeMessage(dst []byte, st *SprotoType, v reflect.Value) []byte {
+	if st.marshaler && !v.IsNil() {
+		dst, err := v.Interface().(Marshaler).MarshalSproto(dst)
+		if err != nil {
+			panic(err)
+		}
+		return dst
 	}
-	return buf[:i]
-}
 
-func encodeMessage(st *SprotoType, v reflect.Value) []byte {
-	headers := make([]uint16, len(st.Fields)*2)   // max header len is fieldNum * 2
-	buffer := make([]byte, EncodeBufferSize)[0:0] // pre-allocate 4k buffer
-
-	tag, offset := -1, 0
-	dataLen := make([]byte, 4, 4)
-	if !v.IsNil() { // struct could be nil in struct array
-		for _, i := range st.order {
-			sf := st.Fields[i]
-			v1 := v.Elem().FieldByIndex(sf.field.Index)
-			nextTag := sf.Tag
-			if nextTag < 0 {
-				continue
-			}
-			if v1.Kind() != reflect.Ptr &&
-				v1.Kind() != reflect.Slice &&
-				v1.Kind() != reflect.Array &&
-				v1.Kind() != reflect.Struct &&
-				v1.Kind() != reflect.Map {
-				// 替内部处理取地址
-				v1 = v1.Addr()
-			}
-			if header, isNil := sf.headerEnc(sf, v1); !isNil {
-				if skip := skipTag(tag, nextTag); skip > 0 {
-					headers[offset] = skip
-					offset++
-				}
-				headers[offset] = header
-				offset++
-				tag = nextTag
-				if sf.enc != nil {
-					if data := sf.enc(sf, v1); data != nil {
-						writeUint32(dataLen, uint32(len(data)))
-						buffer = Append(buffer, dataLen)
-						buffer = Append(buffer, data)
-					}
-				}
-			}
+	if v.IsNil() { // struct could be nil in struct array
+		w := NewMessageWriter(dst, 0)
+		return w.Bytes()
+	}
+
+	var unknown UnknownFields
+	if st.unknown != nil {
+		unknown = v.Elem().FieldByIndex(st.unknown).Interface().(UnknownFields)
+	}
+	w := NewMessageWriter(dst, len(st.Fields)+len(unknown))
+	u := 0
+	for _, i := range st.order {
+		sf := st.Fields[i]
+		if sf.Tag < 0 {
+			continue
+		}
+		u = writeUnknownFields(&w, unknown, u, sf.Tag)
+		v1 := v.Elem().FieldByIndex(sf.field.Index)
+		if v1.Kind() != reflect.Ptr &&
+			v1.Kind() != reflect.Slice &&
+			v1.Kind() != reflect.Array &&
+			v1.Kind() != reflect.Struct &&
+			v1.Kind() != reflect.Map {
+			// 替内部处理取地址
+			v1 = v1.Addr()
 		}
+		header, isNil := sf.headerEnc(sf, v1)
+		if isNil {
+			continue
+		}
+		if header != 0 || sf.enc == nil {
+			// value embedded in header
+			w.header(sf.Tag, header)
+			continue
+		}
+		offset := w.beginData(sf.Tag)
+		w.buf = sf.enc(sf, w.buf, v1)
+		w.endData(offset)
 	}
-	return Append(encodeHeaders(headers[:offset], len(buffer)), buffer)
+	writeUnknownFields(&w, unknown, u, TagMax+1)
+	return w.Bytes()
 }
 
-func Encode(sp interface{}) (_ []byte, err error) {
+// EncodeAppend appends the encoded message of sp to dst and returns the
+// extended buffer. It allocates nothing if dst has enough capacity and sp
+// contains no map.
+func EncodeAppend(dst []byte, sp interface{}) (data []byte, err error) {
 	defer func() {
 		if obj := recover(); obj != nil {
-			err = fmt.Errorf("sproto: Encode recovered from panic, err: %v", obj)
+			data = dst
+			if e, ok := obj.(error); ok {
+				err = fmt.Errorf("sproto: Encode recovered from panic, err: %w", e)
+			} else {
+				err = fmt.Errorf("sproto: Encode recovered from panic, err: %v", obj)
+			}
 		}
 	}()
 	t, v, err := getbase(sp)
 	if err != nil {
-		return nil, err
+		return dst, err
+	}
+	if m, ok := sp.(Marshaler); ok {
+		return m.MarshalSproto(dst)
 	}
 
 	st, err := GetSprotoType(t.Elem())
+	if err != nil {
+		return dst, err
+	}
+	return encodeMessage(dst, st, v), nil
+}
+
+func Encode(sp interface{}) ([]byte, error) {
+	return EncodeAppend(nil, sp)
+}
+
+// Encoder encodes messages into its internal buffer, which is reused across
+// calls. It's not safe for concurrent use.
+type Encoder struct {
+	buf []byte
+
+	// stream
+	w       io.Writer
+	framing Framing
+	pack    bool
+}
+
+// Marshal returns the encoded message of sp, the result is only valid until
+// the next call of Encoder.
+func (e *Encoder) Marshal(sp interface{}) ([]byte, error) {
+	if e.buf == nil {
+		e.buf = make([]byte, 0, EncodeBufferSize)
+	}
+	data, err := EncodeAppend(e.buf[:0], sp)
 	if err != nil {
 		return nil, err
 	}
-	return encodeMessage(st, v), nil
+	e.buf = data
+	return data, nil
 }
 
 func MustEncode(sp interface{}) []byte {
@@ -367,3 +367,30 @@ func resetEncodeTestEnv() {
 		},
 	}
 }
+
+type UintMSG struct {
+	U32  *uint32  `sproto:"integer,0"`
+	U64  uint64   `sproto:"integer,1"`
+	U    uint     `sproto:"integer,2"`
+	U32s []uint32 `sproto:"integer,3,array"`
+}
+
+func TestUintEncode(t *testing.T) {
+	msg := UintMSG{
+		U32:  Uint32(0x80000000),
+		U64:  1 << 40,
+		U:    100000,
+		U32s: []uint32{1, 0xffffffff},
+	}
+	data, err := Encode(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg2 UintMSG
+	if _, err := Decode(data, &msg2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, msg2) {
+		t.Fatalf("uint message mismatch: %+v", msg2)
+	}
+}
@@ -21,33 +21,26 @@ func (e *Echo) Ping(req *sproto_echo.PingRequest, resp *sproto_echo.PingResponse
 	*e++
 }
 
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
-	server, _ := sproto.NewService(conn, sproto_echo.Protocols)
-	server.Register(&echo)
-	err := server.Dispatch()
-	if err == io.EOF {
-		log.Printf("client(%v) closed", conn.RemoteAddr())
-	} else {
-		log.Printf("client(%v) failed:%s", conn.RemoteAddr(), err)
-	}
-}
-
 func main() {
-	ln, err := net.Listen("tcp", ":8686")
+	server, err := sproto.NewServer(sproto_echo.Protocols)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := server.Register(&echo); err != nil {
+		log.Fatal(err)
+	}
+	server.SetOnDisconnect(func(s *sproto.Service, err error) {
+		conn := s.Conn().(net.Conn)
+		if err == io.EOF {
+			log.Printf("client(%v) closed", conn.RemoteAddr())
+		} else {
+			log.Printf("client(%v) failed:%s", conn.RemoteAddr(), err)
+		}
+	})
 
 	go func() {
 		log.Println(http.ListenAndServe(":6060", nil))
 	}()
 
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Fatal(err)
-		}
-		go handleConnection(conn)
-	}
+	log.Fatal(server.ListenAndServe(":8686"))
 }
@@ -0,0 +1,3 @@
+package sproto_echo
+
+//go:generate go run github.com/xjdrew/gosproto/cmd/sprotogen -o echo.go echo.sproto
@@ -0,0 +1,3 @@
+package sproto_types
+
+//go:generate go run github.com/xjdrew/gosproto/cmd/sprotogen -marshal -o types.go types.sproto
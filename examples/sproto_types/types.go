@@ -1,9 +1,8 @@
-// Code generated by sprotodump
+// Code generated by sprotogen. DO NOT EDIT.
 // source: types.sproto
-// DO NOT EDIT!
 
 /*
-   Package sproto_types is a generated sproto package.
+Package sproto_types is a generated sproto package.
 */
 package sproto_types
 
@@ -13,46 +12,417 @@ import (
 	"github.com/xjdrew/gosproto"
 )
 
-// avoids "imported but not used"
-var _ reflect.Type
-
 type Person struct {
 	Name   *string              `sproto:"string,0"`
 	Id     *int64               `sproto:"integer,1"`
 	Email  *string              `sproto:"string,2"`
 	Phone  []*PersonPhoneNumber `sproto:"struct,3,array"`
-	Height *int64               `sproto:"integer,4"`
+	Height *float64             `sproto:"integer,4,decimal=2"`
 	Data   []byte               `sproto:"binary,5"`
 	Weight *float64             `sproto:"double,6"`
 	Pics   [][]byte             `sproto:"binary,7,array"`
 }
 
+func (m *Person) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 8)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Name != nil {
+		w.WriteString(0, *m.Name)
+	}
+	if m.Id != nil {
+		w.WriteInt(1, *m.Id)
+	}
+	if m.Email != nil {
+		w.WriteString(2, *m.Email)
+	}
+	if m.Phone != nil {
+		offset := w.BeginArray(3)
+		for _, v := range m.Phone {
+			if err := w.WriteElem(v); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	if m.Height != nil {
+		if err := w.WriteDecimal(4, *m.Height, 2); err != nil {
+			return dst, err
+		}
+	}
+	if m.Data != nil {
+		w.WriteBytes(5, m.Data)
+	}
+	if m.Weight != nil {
+		w.WriteDouble(6, *m.Weight)
+	}
+	if m.Pics != nil {
+		w.WriteBytesSlice(7, m.Pics)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *Person) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *Person) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = Person{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			m.Name = &v
+		case 1:
+			v, err := r.ReadInt()
+			if err != nil {
+				return err
+			}
+			m.Id = &v
+		case 2:
+			v, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			m.Email = &v
+		case 3:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Phone = make([]*PersonPhoneNumber, 0)
+			for arr.Next() {
+				v := new(PersonPhoneNumber)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				m.Phone = append(m.Phone, v)
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		case 4:
+			v, err := r.ReadDecimal(2)
+			if err != nil {
+				return err
+			}
+			m.Height = &v
+		case 5:
+			v, err := r.ReadBytes()
+			if err != nil {
+				return err
+			}
+			m.Data = v
+		case 6:
+			v, err := r.ReadDouble()
+			if err != nil {
+				return err
+			}
+			m.Weight = &v
+		case 7:
+			v, err := r.ReadBytesSlice()
+			if err != nil {
+				return err
+			}
+			m.Pics = v
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type PersonPhoneNumber struct {
 	Number *string `sproto:"string,0"`
 	Type   *int64  `sproto:"integer,1"`
 }
 
+func (m *PersonPhoneNumber) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 2)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Number != nil {
+		w.WriteString(0, *m.Number)
+	}
+	if m.Type != nil {
+		w.WriteInt(1, *m.Type)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *PersonPhoneNumber) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *PersonPhoneNumber) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = PersonPhoneNumber{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			m.Number = &v
+		case 1:
+			v, err := r.ReadInt()
+			if err != nil {
+				return err
+			}
+			m.Type = &v
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type CreditCard struct {
 	CardNum *string `sproto:"string,0"`
 	Owner   *Person `sproto:"struct,1"`
 }
 
+func (m *CreditCard) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 2)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.CardNum != nil {
+		w.WriteString(0, *m.CardNum)
+	}
+	if m.Owner != nil {
+		if err := w.WriteStruct(1, m.Owner); err != nil {
+			return dst, err
+		}
+	}
+	return w.Bytes(), nil
+}
+
+func (m *CreditCard) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *CreditCard) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = CreditCard{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			m.CardNum = &v
+		case 1:
+			v := new(Person)
+			if err := r.ReadStruct(v); err != nil {
+				return err
+			}
+			m.Owner = v
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type Bank struct {
 	Cards        map[string]*Person `sproto:"struct,0,array,key=0,value=1,subtype=mapItemCards"`
 	Clients      map[int64]*Person  `sproto:"struct,1,array,key=1"`
 	mapItemCards *CreditCard
 }
 
+func (m *Bank) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 2)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Cards != nil {
+		offset := w.BeginArray(0)
+		for k, v := range m.Cards {
+			k := k
+			item := &CreditCard{CardNum: &k, Owner: v}
+			if err := w.WriteElem(item); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	if m.Clients != nil {
+		offset := w.BeginArray(1)
+		for _, v := range m.Clients {
+			if err := w.WriteElem(v); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *Bank) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *Bank) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = Bank{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Cards = make(map[string]*Person)
+			for arr.Next() {
+				v := new(CreditCard)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.CardNum == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.Cards[*v.CardNum] = v.Owner
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		case 1:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Clients = make(map[int64]*Person)
+			for arr.Next() {
+				v := new(Person)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Id == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.Clients[*v.Id] = v
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type SimpleItem struct {
 	Key   *int64  `sproto:"integer,3"`
 	Value *string `sproto:"string,5"`
 }
 
+func (m *SimpleItem) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 2)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Key != nil {
+		w.WriteInt(3, *m.Key)
+	}
+	if m.Value != nil {
+		w.WriteString(5, *m.Value)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *SimpleItem) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *SimpleItem) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = SimpleItem{}
+	for r.Next() {
+		switch r.Tag() {
+		case 3:
+			v, err := r.ReadInt()
+			if err != nil {
+				return err
+			}
+			m.Key = &v
+		case 5:
+			v, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			m.Value = &v
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type NodeItem struct {
 	Id   *int64    `sproto:"integer,0"`
 	Node *NodeItem `sproto:"struct,1"`
 }
 
+func (m *NodeItem) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 2)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Id != nil {
+		w.WriteInt(0, *m.Id)
+	}
+	if m.Node != nil {
+		if err := w.WriteStruct(1, m.Node); err != nil {
+			return dst, err
+		}
+	}
+	return w.Bytes(), nil
+}
+
+func (m *NodeItem) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *NodeItem) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = NodeItem{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadInt()
+			if err != nil {
+				return err
+			}
+			m.Id = &v
+		case 1:
+			v := new(NodeItem)
+			if err := r.ReadStruct(v); err != nil {
+				return err
+			}
+			m.Node = v
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type ArraysStruct struct {
 	IntArr    []int64       `sproto:"integer,1,array"`
 	BoolArr   []bool        `sproto:"boolean,2,array"`
@@ -62,17 +432,235 @@ type ArraysStruct struct {
 	StructArr []*SimpleItem `sproto:"struct,6,array"`
 }
 
+func (m *ArraysStruct) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 6)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.IntArr != nil {
+		w.WriteInt64Slice(1, m.IntArr)
+	}
+	if m.BoolArr != nil {
+		w.WriteBoolSlice(2, m.BoolArr)
+	}
+	if m.StrArr != nil {
+		w.WriteStringSlice(3, m.StrArr)
+	}
+	if m.BinArr != nil {
+		w.WriteBytesSlice(4, m.BinArr)
+	}
+	if m.DoubleArr != nil {
+		w.WriteDoubleSlice(5, m.DoubleArr)
+	}
+	if m.StructArr != nil {
+		offset := w.BeginArray(6)
+		for _, v := range m.StructArr {
+			if err := w.WriteElem(v); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *ArraysStruct) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *ArraysStruct) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = ArraysStruct{}
+	for r.Next() {
+		switch r.Tag() {
+		case 1:
+			v, err := r.ReadInt64Slice()
+			if err != nil {
+				return err
+			}
+			m.IntArr = v
+		case 2:
+			v, err := r.ReadBoolSlice()
+			if err != nil {
+				return err
+			}
+			m.BoolArr = v
+		case 3:
+			v, err := r.ReadStringSlice()
+			if err != nil {
+				return err
+			}
+			m.StrArr = v
+		case 4:
+			v, err := r.ReadBytesSlice()
+			if err != nil {
+				return err
+			}
+			m.BinArr = v
+		case 5:
+			v, err := r.ReadDoubleSlice()
+			if err != nil {
+				return err
+			}
+			m.DoubleArr = v
+		case 6:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.StructArr = make([]*SimpleItem, 0)
+			for arr.Next() {
+				v := new(SimpleItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				m.StructArr = append(m.StructArr, v)
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type NestedMapItem struct {
 	Id            *int64            `sproto:"integer,0"`
 	Nested        map[int64]*string `sproto:"struct,1,array,key=3,value=5,subtype=mapItemNested"`
 	mapItemNested *SimpleItem
 }
 
+func (m *NestedMapItem) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 2)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Id != nil {
+		w.WriteInt(0, *m.Id)
+	}
+	if m.Nested != nil {
+		offset := w.BeginArray(1)
+		for k, v := range m.Nested {
+			k := k
+			item := &SimpleItem{Key: &k, Value: v}
+			if err := w.WriteElem(item); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *NestedMapItem) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *NestedMapItem) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = NestedMapItem{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadInt()
+			if err != nil {
+				return err
+			}
+			m.Id = &v
+		case 1:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Nested = make(map[int64]*string)
+			for arr.Next() {
+				v := new(SimpleItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Key == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.Nested[*v.Key] = v.Value
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type NestedArrayItem struct {
 	Id     *int64        `sproto:"integer,0"`
 	Nested []*SimpleItem `sproto:"struct,1,array"`
 }
 
+func (m *NestedArrayItem) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 2)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Id != nil {
+		w.WriteInt(0, *m.Id)
+	}
+	if m.Nested != nil {
+		offset := w.BeginArray(1)
+		for _, v := range m.Nested {
+			if err := w.WriteElem(v); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *NestedArrayItem) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *NestedArrayItem) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = NestedArrayItem{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadInt()
+			if err != nil {
+				return err
+			}
+			m.Id = &v
+		case 1:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Nested = make([]*SimpleItem, 0)
+			for arr.Next() {
+				v := new(SimpleItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				m.Nested = append(m.Nested, v)
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type MapStruct struct {
 	Map1        map[int64]*SimpleItem `sproto:"struct,9,array,key=3"`
 	Map2        map[int64]*string     `sproto:"struct,10,array,key=3,value=5,subtype=mapItemMap2"`
@@ -82,6 +670,147 @@ type MapStruct struct {
 	mapItemMap3 *NodeItem
 }
 
+func (m *MapStruct) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 4)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Map1 != nil {
+		offset := w.BeginArray(9)
+		for _, v := range m.Map1 {
+			if err := w.WriteElem(v); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	if m.Map2 != nil {
+		offset := w.BeginArray(10)
+		for k, v := range m.Map2 {
+			k := k
+			item := &SimpleItem{Key: &k, Value: v}
+			if err := w.WriteElem(item); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	if m.Map3 != nil {
+		offset := w.BeginArray(20)
+		for k, v := range m.Map3 {
+			k := k
+			item := &NodeItem{Id: &k, Node: v}
+			if err := w.WriteElem(item); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	if m.Map4 != nil {
+		offset := w.BeginArray(21)
+		for _, v := range m.Map4 {
+			if err := w.WriteElem(v); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *MapStruct) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *MapStruct) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = MapStruct{}
+	for r.Next() {
+		switch r.Tag() {
+		case 9:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Map1 = make(map[int64]*SimpleItem)
+			for arr.Next() {
+				v := new(SimpleItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Key == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.Map1[*v.Key] = v
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		case 10:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Map2 = make(map[int64]*string)
+			for arr.Next() {
+				v := new(SimpleItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Key == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.Map2[*v.Key] = v.Value
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		case 20:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Map3 = make(map[int64]*NodeItem)
+			for arr.Next() {
+				v := new(NodeItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Id == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.Map3[*v.Id] = v.Node
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		case 21:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.Map4 = make(map[int64]*NodeItem)
+			for arr.Next() {
+				v := new(NodeItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Id == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.Map4[*v.Id] = v
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type NestedMapStruct struct {
 	NestedMap1        map[int64]map[int64]*string `sproto:"struct,30,array,key=0,value=1,subtype=mapItemNestedMap1"`
 	NestedMap2        map[int64]*NestedMapItem    `sproto:"struct,31,array,key=0"`
@@ -90,14 +819,195 @@ type NestedMapStruct struct {
 	mapItemNestedArr  *NestedArrayItem
 }
 
+func (m *NestedMapStruct) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 3)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.NestedMap1 != nil {
+		offset := w.BeginArray(30)
+		for k, v := range m.NestedMap1 {
+			k := k
+			item := &NestedMapItem{Id: &k, Nested: v}
+			if err := w.WriteElem(item); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	if m.NestedMap2 != nil {
+		offset := w.BeginArray(31)
+		for _, v := range m.NestedMap2 {
+			if err := w.WriteElem(v); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	if m.NestedArr != nil {
+		offset := w.BeginArray(40)
+		for k, v := range m.NestedArr {
+			k := k
+			item := &NestedArrayItem{Id: &k, Nested: v}
+			if err := w.WriteElem(item); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *NestedMapStruct) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *NestedMapStruct) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = NestedMapStruct{}
+	for r.Next() {
+		switch r.Tag() {
+		case 30:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.NestedMap1 = make(map[int64]map[int64]*string)
+			for arr.Next() {
+				v := new(NestedMapItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Id == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.NestedMap1[*v.Id] = v.Nested
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		case 31:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.NestedMap2 = make(map[int64]*NestedMapItem)
+			for arr.Next() {
+				v := new(NestedMapItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Id == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.NestedMap2[*v.Id] = v
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		case 40:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return err
+			}
+			m.NestedArr = make(map[int64][]*SimpleItem)
+			for arr.Next() {
+				v := new(NestedArrayItem)
+				if err := arr.ReadStruct(v); err != nil {
+					return err
+				}
+				if v.Id == nil {
+					return sproto.ErrNilMapKey
+				}
+				m.NestedArr[*v.Id] = v.Nested
+			}
+			if err := arr.Err(); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type ApiRequest struct {
 	Ping *string `sproto:"string,0"`
 }
 
+func (m *ApiRequest) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 1)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Ping != nil {
+		w.WriteString(0, *m.Ping)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *ApiRequest) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *ApiRequest) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = ApiRequest{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			m.Ping = &v
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 type ApiResponse struct {
 	Pong *string `sproto:"string,0"`
 }
 
+func (m *ApiResponse) MarshalSproto(dst []byte) ([]byte, error) {
+	w := sproto.NewMessageWriter(dst, 1)
+	if m == nil {
+		return w.Bytes(), nil
+	}
+	if m.Pong != nil {
+		w.WriteString(0, *m.Pong)
+	}
+	return w.Bytes(), nil
+}
+
+func (m *ApiResponse) UnmarshalSproto(data []byte) (int, error) {
+	return sproto.Decode(data, m)
+}
+
+func (m *ApiResponse) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+	*m = ApiResponse{}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			m.Pong = &v
+		default:
+			if err := r.SkipUnknown(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
 var Name string = "types"
 var Protocols []*sproto.Protocol = []*sproto.Protocol{
 	&sproto.Protocol{
@@ -1,6 +1,8 @@
 package sproto_types
 
 import (
+	"bytes"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -32,7 +34,7 @@ func TestTypes(t *testing.T) {
 				{Number: ptrString("1234567"), Type: ptrInt(1)},
 				{Number: ptrString("8765432"), Type: ptrInt(2)},
 			},
-			Height: ptrInt(178),
+			Height: ptrFloat(1.78),
 			Data:   []byte("extra data"),
 			Weight: ptrFloat(64.3),
 			Pics:   [][]byte{[]byte("image data1"), []byte("image data2")},
@@ -193,3 +195,214 @@ func TestIndexKeyIsNil(t *testing.T) {
 		return
 	}
 }
+
+// same layout as the generated types, but encoded by reflection
+type rawPerson Person
+type rawArraysStruct ArraysStruct
+type rawNodeItem NodeItem
+
+var benchPerson = &Person{
+	Name:  ptrString("David"),
+	Id:    ptrInt(123),
+	Email: ptrString("aaa@example.com"),
+	Phone: []*PersonPhoneNumber{
+		{Number: ptrString("1234567"), Type: ptrInt(1)},
+		{Number: ptrString("8765432"), Type: ptrInt(2)},
+	},
+	Height: ptrFloat(-1.78),
+	Data:   []byte("extra data"),
+	Weight: ptrFloat(64.3),
+	Pics:   [][]byte{[]byte("image data1"), []byte("image data2")},
+}
+
+func TestMarshalerCompatible(t *testing.T) {
+	arrays := &ArraysStruct{
+		IntArr:    []int64{1, -2, 1 << 40},
+		BoolArr:   []bool{true, false},
+		StrArr:    []string{},
+		DoubleArr: []float64{1.23},
+		StructArr: []*SimpleItem{{Key: ptrInt(1)}, {Value: ptrString("v2")}},
+	}
+	node := &NodeItem{Id: ptrInt(1 << 20), Node: &NodeItem{Id: ptrInt(0)}}
+
+	cases := []struct {
+		fast interface{}
+		raw  interface{}
+	}{
+		{benchPerson, (*rawPerson)(benchPerson)},
+		{arrays, (*rawArraysStruct)(arrays)},
+		{node, (*rawNodeItem)(node)},
+	}
+	for _, c := range cases {
+		fastData, err := sproto.Encode(c.fast)
+		if err != nil {
+			t.Fatalf("encode failed, obj: %+v, err: %s", c.fast, err)
+		}
+		rawData, err := sproto.Encode(c.raw)
+		if err != nil {
+			t.Fatalf("encode failed, obj: %+v, err: %s", c.raw, err)
+		}
+		if !reflect.DeepEqual(fastData, rawData) {
+			t.Fatalf("marshaler encoded %v, reflection encoded %v", fastData, rawData)
+		}
+
+		obj := reflect.New(reflect.TypeOf(c.fast).Elem()).Interface()
+		if _, err = sproto.Decode(rawData, obj); err != nil {
+			t.Fatalf("decode failed, obj: %+v, err: %s", c.fast, err)
+		}
+		if !reflect.DeepEqual(obj, c.fast) {
+			t.Fatalf("decode failed, obj: %+v", obj)
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sproto.Encode(benchPerson)
+	}
+}
+
+func BenchmarkEncodeReflect(b *testing.B) {
+	raw := (*rawPerson)(benchPerson)
+	for i := 0; i < b.N; i++ {
+		sproto.Encode(raw)
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data := sproto.MustEncode(benchPerson)
+	var person Person
+	for i := 0; i < b.N; i++ {
+		sproto.Decode(data, &person)
+	}
+}
+
+func BenchmarkDecodeReflect(b *testing.B) {
+	data := sproto.MustEncode(benchPerson)
+	var person rawPerson
+	for i := 0; i < b.N; i++ {
+		sproto.Decode(data, &person)
+	}
+}
+
+func TestMarshalerDecodeError(t *testing.T) {
+	person := &Person{
+		Name: ptrString("Bob"),
+		Phone: []*PersonPhoneNumber{
+			{Number: ptrString("10086")},
+			{Number: ptrString("01234567890")},
+		},
+	}
+	data, err := sproto.Encode(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// corrupt the length of the second phone number
+	offset := bytes.Index(data, []byte("01234567890")) - 4
+	data[offset] = 0xff
+
+	_, err = sproto.Decode(data, &Person{})
+	var de *sproto.DecodeError
+	if !errors.Is(err, sproto.ErrDecode) || !errors.As(err, &de) {
+		t.Fatalf("expect DecodeError, but get %v", err)
+	}
+	if de.Type != "PersonPhoneNumber" || de.Path != "Person.#3[1]" || de.Tag != 0 || de.Offset != offset {
+		t.Fatalf("unexpected error: %+v", de)
+	}
+
+	// every truncated data fails with DecodeError
+	data, _ = sproto.Encode(person)
+	for n := 1; n < len(data); n++ {
+		if _, err := sproto.Decode(data[:n], &Person{}); err != nil && !errors.As(err, &de) {
+			t.Fatalf("truncated at %d, unexpected error: %v", n, err)
+		}
+	}
+}
+
+func TestMarshalerDecodeLimits(t *testing.T) {
+	node := &NodeItem{Id: ptrInt(1), Node: &NodeItem{Id: ptrInt(2), Node: &NodeItem{Id: ptrInt(3)}}}
+	person := &Person{
+		Name:  ptrString("Bob"),
+		Email: ptrString("bob@example.com"),
+		Data:  make([]byte, 1024),
+	}
+	arrays := []*ArraysStruct{
+		{IntArr: []int64{1, 2, 3}},
+		{BoolArr: []bool{true, false, true}},
+		{StrArr: []string{"a", "b", "c"}},
+		{BinArr: [][]byte{{1}, {2}, {3}}},
+		{DoubleArr: []float64{1, 2, 3}},
+		{StructArr: []*SimpleItem{{}, {}, {}}},
+	}
+
+	type limitCase struct {
+		name string
+		sp   interface{}
+		opts sproto.DecodeOptions
+		err  error
+	}
+	cases := []limitCase{
+		{"depth", node, sproto.DecodeOptions{MaxDepth: 2}, sproto.ErrDepthLimit},
+		{"bytes", person, sproto.DecodeOptions{MaxBytesLen: 100}, sproto.ErrBytesLimit},
+		{"alloc", person, sproto.DecodeOptions{MaxAllocBytes: 100}, sproto.ErrAllocLimit},
+		{"fields", person, sproto.DecodeOptions{MaxFields: 2}, sproto.ErrFieldsLimit},
+	}
+	for i, sp := range arrays {
+		cases = append(cases, limitCase{"array" + string(rune('0'+i)), sp, sproto.DecodeOptions{MaxArrayLen: 2}, sproto.ErrArrayLimit})
+	}
+	for _, tc := range cases {
+		data, err := sproto.Encode(tc.sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := reflect.New(reflect.TypeOf(tc.sp).Elem()).Interface()
+		if _, err := sproto.Decode(data, v); err != nil {
+			t.Fatalf("%s: decode without limit failed: %s", tc.name, err)
+		}
+		v = reflect.New(reflect.TypeOf(tc.sp).Elem()).Interface()
+		_, err = tc.opts.Decode(data, v)
+		var de *sproto.DecodeError
+		if !errors.Is(err, tc.err) || !errors.As(err, &de) {
+			t.Fatalf("%s: expect %v, but get %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestMarshalerDecodeOptions(t *testing.T) {
+	person := &Person{Name: ptrString("Bob"), Email: ptrString("bob@example.com"), Data: []byte{1, 2, 3}}
+	data := sproto.MustEncode(person)
+
+	var p Person
+	if _, err := (sproto.DecodeOptions{ZeroCopy: true}).Decode(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if i := bytes.Index(data, p.Data); i < 0 || &data[i] != &p.Data[0] {
+		t.Fatal("ZeroCopy should alias data")
+	}
+
+	// Email(tag 2) is unknown to PersonPhoneNumber
+	opts := sproto.DecodeOptions{UnknownTags: sproto.UnknownError}
+	_, err := opts.Decode(data, &PersonPhoneNumber{})
+	var de *sproto.DecodeError
+	if !errors.Is(err, sproto.ErrUnknownTag) || !errors.As(err, &de) || de.Tag != 2 {
+		t.Fatalf("expect ErrUnknownTag, but get %v", err)
+	}
+
+	var tags []int
+	opts = sproto.DecodeOptions{
+		UnknownTags: sproto.UnknownCallback,
+		OnUnknownTag: func(typ reflect.Type, f sproto.UnknownField) error {
+			if typ != reflect.TypeOf(PersonPhoneNumber{}) {
+				t.Fatalf("unexpected type: %v", typ)
+			}
+			tags = append(tags, f.Tag)
+			return nil
+		},
+	}
+	if _, err := opts.Decode(data, &PersonPhoneNumber{}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []int{2, 5}) {
+		t.Fatalf("unexpected unknown tags: %v", tags)
+	}
+}
@@ -0,0 +1,134 @@
+package sproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxPacketSize limits packets of LengthFramer whose framing allows
+// more than MSG_MAX_LEN.
+const DefaultMaxPacketSize = 4 * 1024 * 1024
+
+var ErrPacketTooLarge = errors.New("sproto: packet too large")
+
+// Framer delimits packets of a Service on its connection. Reads and writes
+// are serialized by Service separately, the packet returned by ReadPacket may
+// be overwritten by the next read.
+type Framer interface {
+	ReadPacket(r io.Reader) ([]byte, error)
+	WritePacket(w io.Writer, packet []byte) error
+}
+
+// LengthFramer prefixes packets with their big-endian length. The zero value
+// is the skynet compatible framing of Service: 2-byte length and packets up
+// to MSG_MAX_LEN.
+type LengthFramer struct {
+	Framing Framing // FrameLen16 or FrameLen32
+
+	// Fragment splits packets of FrameLen16 into frames, a frame of
+	// MSG_MAX_LEN bytes is followed by the rest of the packet, which may be
+	// empty. Packets shorter than MSG_MAX_LEN are framed as usual.
+	Fragment bool
+
+	// max packet sizes, 0 means MSG_MAX_LEN for FrameLen16 without Fragment
+	// and DefaultMaxPacketSize otherwise
+	MaxRead  int
+	MaxWrite int
+
+	rdbuf []byte
+	wrbuf []byte
+}
+
+func (f *LengthFramer) maxSize(max int) int {
+	if max > 0 {
+		return max
+	}
+	if f.Framing == FrameLen16 && !f.Fragment {
+		return MSG_MAX_LEN
+	}
+	return DefaultMaxPacketSize
+}
+
+func (f *LengthFramer) check() error {
+	if f.Framing != FrameLen16 && f.Framing != FrameLen32 {
+		return fmt.Errorf("sproto: framer doesn't support framing %d", f.Framing)
+	}
+	return nil
+}
+
+func (f *LengthFramer) ReadPacket(r io.Reader) ([]byte, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	max := f.maxSize(f.MaxRead)
+	buf := f.rdbuf[:0]
+	var err error
+	for {
+		var sz int
+		offset := len(buf)
+		if f.Framing == FrameLen32 {
+			if buf, err = readAppend(r, buf, 4, offset == 0); err != nil {
+				return nil, err
+			}
+			sz = int(binary.BigEndian.Uint32(buf[offset:]))
+		} else {
+			if buf, err = readAppend(r, buf, 2, offset == 0); err != nil {
+				return nil, err
+			}
+			sz = int(binary.BigEndian.Uint16(buf[offset:]))
+		}
+		buf = buf[:offset]
+		if offset+sz > max {
+			return nil, fmt.Errorf("%w, size(%d) exceeds %d", ErrPacketTooLarge, offset+sz, max)
+		}
+		buf, err = readAppend(r, buf, sz, false)
+		f.rdbuf = buf
+		if err != nil {
+			return nil, err
+		}
+		if !f.Fragment || f.Framing != FrameLen16 || sz < MSG_MAX_LEN {
+			return buf, nil
+		}
+	}
+}
+
+func (f *LengthFramer) WritePacket(w io.Writer, packet []byte) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	sz := len(packet)
+	if max := f.maxSize(f.MaxWrite); sz > max {
+		return fmt.Errorf("%w, size(%d) exceeds %d", ErrPacketTooLarge, sz, max)
+	}
+	buf := f.wrbuf[:0]
+	switch {
+	case f.Framing == FrameLen32:
+		buf = appendUint32(buf, 0)
+		binary.BigEndian.PutUint32(buf, uint32(sz))
+		buf = append(buf, packet...)
+	case f.Fragment:
+		for {
+			n := len(packet)
+			if n > MSG_MAX_LEN {
+				n = MSG_MAX_LEN
+			}
+			buf = append(buf, uint8(n>>8), uint8(n))
+			buf = append(buf, packet[:n]...)
+			packet = packet[n:]
+			if n < MSG_MAX_LEN {
+				break
+			}
+		}
+	default:
+		if sz > MSG_MAX_LEN {
+			return fmt.Errorf("%w, size(%d) exceeds %d", ErrPacketTooLarge, sz, MSG_MAX_LEN)
+		}
+		buf = append(buf, uint8(sz>>8), uint8(sz))
+		buf = append(buf, packet...)
+	}
+	f.wrbuf = buf
+	_, err := w.Write(buf)
+	return err
+}
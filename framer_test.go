@@ -0,0 +1,103 @@
+package sproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLengthFramer(t *testing.T) {
+	framers := []*LengthFramer{
+		{},
+		{Framing: FrameLen32},
+		{Fragment: true},
+	}
+	sizes := [][]int{
+		{0, 10, MSG_MAX_LEN},
+		{0, 10, MSG_MAX_LEN, 3 * MSG_MAX_LEN, 200000},
+		{0, 10, MSG_MAX_LEN - 1, MSG_MAX_LEN, MSG_MAX_LEN + 1, 2 * MSG_MAX_LEN, 200000},
+	}
+	for i, f := range framers {
+		var rw bytes.Buffer
+		var packets [][]byte
+		for _, sz := range sizes[i] {
+			packet := bytes.Repeat([]byte{byte(sz)}, sz)
+			if err := f.WritePacket(&rw, packet); err != nil {
+				t.Fatalf("framer %d: write %d bytes failed: %v", i, sz, err)
+			}
+			packets = append(packets, packet)
+		}
+		for _, packet := range packets {
+			p, err := f.ReadPacket(&rw)
+			if err != nil || !bytes.Equal(p, packet) {
+				t.Fatalf("framer %d: read %d bytes failed: %v", i, len(packet), err)
+			}
+		}
+		if _, err := f.ReadPacket(&rw); err != io.EOF {
+			t.Fatalf("framer %d: expected EOF, got %v", i, err)
+		}
+	}
+}
+
+func TestLengthFramerLimits(t *testing.T) {
+	var rw bytes.Buffer
+	f := &LengthFramer{}
+	if err := f.WritePacket(&rw, make([]byte, MSG_MAX_LEN+1)); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f = &LengthFramer{Fragment: true, MaxWrite: 1000}
+	if err := f.WritePacket(&rw, make([]byte, 1001)); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f = &LengthFramer{Framing: FrameLen32}
+	f.WritePacket(&rw, make([]byte, 1001))
+	f.MaxRead = 1000
+	if _, err := f.ReadPacket(&rw); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rw.Reset()
+	f = &LengthFramer{Fragment: true, MaxRead: MSG_MAX_LEN + 10}
+	f.WritePacket(&rw, make([]byte, MSG_MAX_LEN+20))
+	if _, err := f.ReadPacket(&rw); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rw.Reset()
+	rw.Write([]byte{0, 10, 1, 2})
+	if _, err := (&LengthFramer{}).ReadPacket(&rw); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServiceLargeMessage(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, protocols)
+	server, _ := NewService(rw, protocols)
+	server.Register(&inst)
+	input := strings.Repeat("large message", 20000)
+
+	if _, err := client.Go("test.foobar", &FoobarRequest{What: &input}, nil); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client.SetFramer(&LengthFramer{Framing: FrameLen32})
+	server.SetFramer(&LengthFramer{Framing: FrameLen32})
+	call, err := client.Go("test.foobar", &FoobarRequest{What: &input}, nil)
+	if err != nil {
+		t.Fatalf("client call failed:%s", err)
+	}
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if call = <-call.Done; *call.Resp.(*FoobarResponse).What != input {
+		t.Fatal("unexpected response")
+	}
+}
@@ -0,0 +1,54 @@
+package sproto
+
+import (
+	"context"
+)
+
+// Handler handles a request and returns its response, which is nil if the
+// protocol has no response.
+type Handler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// ServerInterceptor runs around the handler of requests of protocol p, it
+// calls handler to go on.
+type ServerInterceptor func(ctx context.Context, p *Protocol, req interface{}, handler Handler) (interface{}, error)
+
+// Invoker sends a request of protocol p and returns its response, which is
+// nil if the protocol has no response.
+type Invoker func(ctx context.Context, p *Protocol, req interface{}) (interface{}, error)
+
+// ClientInterceptor runs around calls and invocations of protocol p, it calls
+// invoker to go on.
+type ClientInterceptor func(ctx context.Context, p *Protocol, req interface{}, invoker Invoker) (interface{}, error)
+
+// SetServerInterceptors sets interceptors of received requests, the first
+// one is the outermost. It should be called before dispatching.
+func (s *Service) SetServerInterceptors(interceptors ...ServerInterceptor) {
+	s.serverInterceptors = interceptors
+}
+
+// SetClientInterceptors sets interceptors of calls and invocations, the first
+// one is the outermost. Calls by Go with interceptors run on a new goroutine.
+// It should be called before sending requests.
+func (s *Service) SetClientInterceptors(interceptors ...ClientInterceptor) {
+	s.clientInterceptors = interceptors
+}
+
+func (s *Service) interceptRequest(ctx context.Context, p *Protocol, req interface{}, handler Handler) (interface{}, error) {
+	for i := len(s.serverInterceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.serverInterceptors[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, p, req, next)
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (s *Service) interceptCall(ctx context.Context, p *Protocol, req interface{}, invoker Invoker) (interface{}, error) {
+	for i := len(s.clientInterceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.clientInterceptors[i], invoker
+		invoker = func(ctx context.Context, p *Protocol, req interface{}) (interface{}, error) {
+			return interceptor(ctx, p, req, next)
+		}
+	}
+	return invoker(ctx, p, req)
+}
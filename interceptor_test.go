@@ -0,0 +1,107 @@
+package sproto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestServerInterceptors(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, protocols)
+	server, _ := NewService(rw, protocols)
+	server.Register(&inst)
+
+	var trace []string
+	tracer := func(tag string) ServerInterceptor {
+		return func(ctx context.Context, p *Protocol, req interface{}, handler Handler) (interface{}, error) {
+			trace = append(trace, tag+">"+p.Name)
+			resp, err := handler(ctx, req)
+			trace = append(trace, tag+"<"+p.Name)
+			return resp, err
+		}
+	}
+	errDenied := &RemoteError{Code: 403, Message: "denied"}
+	auth := func(ctx context.Context, p *Protocol, req interface{}, handler Handler) (interface{}, error) {
+		if r, ok := req.(*FoobarRequest); ok && *r.What == "guest" {
+			return nil, errDenied
+		}
+		return handler(ctx, req)
+	}
+	server.SetServerInterceptors(tracer("a"), tracer("b"), auth)
+
+	call, _ := client.Go("test.foobar", &FoobarRequest{What: String("hello")}, nil)
+	client.Invoke("test.bar", nil)
+	server.DispatchOnce()
+	server.DispatchOnce()
+	client.DispatchOnce()
+	if call = <-call.Done; *call.Resp.(*FoobarResponse).What != "hello" {
+		t.Fatalf("unexpected response:%v", call.Resp)
+	}
+	expected := []string{
+		"a>test.foobar", "b>test.foobar", "b<test.foobar", "a<test.foobar",
+		"a>test.bar", "b>test.bar", "b<test.bar", "a<test.bar",
+	}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Fatalf("unexpected trace:%v", trace)
+	}
+
+	call, _ = client.Go("test.foobar", &FoobarRequest{What: String("guest")}, nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	client.DispatchOnce()
+	var re *RemoteError
+	if call = <-call.Done; !errors.As(call.Err, &re) || re.Code != 403 || re.Message != "denied" {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+}
+
+func TestClientInterceptors(t *testing.T) {
+	local, remote := net.Pipe()
+	client, _ := NewService(local, protocols)
+	server, _ := NewService(remote, protocols)
+	server.Register(&inst)
+	go client.Dispatch()
+	go server.Dispatch()
+	defer client.Close()
+	defer server.Close()
+
+	var trace []string
+	client.SetClientInterceptors(
+		func(ctx context.Context, p *Protocol, req interface{}, invoker Invoker) (interface{}, error) {
+			trace = append(trace, "a>"+p.Name)
+			resp, err := invoker(ctx, p, req)
+			trace = append(trace, "a<"+p.Name)
+			return resp, err
+		},
+		func(ctx context.Context, p *Protocol, req interface{}, invoker Invoker) (interface{}, error) {
+			if r, ok := req.(*FoobarRequest); ok {
+				req = &FoobarRequest{What: String(*r.What + "!")}
+			}
+			return invoker(ctx, p, req)
+		},
+	)
+
+	if err := client.Invoke("test.bar", nil); err != nil {
+		t.Fatalf("invoke failed:%s", err)
+	}
+	call, err := client.Go("test.foobar", &FoobarRequest{What: String("hello")}, nil)
+	if err != nil {
+		t.Fatalf("client call failed:%s", err)
+	}
+	if call = <-call.Done; call.Err != nil || *call.Resp.(*FoobarResponse).What != "hello!" {
+		t.Fatalf("unexpected response:%v, err:%v", call.Resp, call.Err)
+	}
+	resp, err := client.Call("test.foo", nil)
+	if err != nil || !*resp.(*FooResponse).Ok {
+		t.Fatalf("unexpected response:%v, err:%v", resp, err)
+	}
+	expected := []string{"a>test.bar", "a<test.bar", "a>test.foobar", "a<test.foobar", "a>test.foo", "a<test.foo"}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Fatalf("unexpected trace:%v", trace)
+	}
+}
@@ -225,6 +225,10 @@ func TestMapToArray(t *testing.T) {
 		return arrayMsg2.SimpleMap[i].K < arrayMsg2.SimpleMap[j].K
 	})
 
+	sort.Slice(arrayMsg2.SimpleMapPtr, func(i, j int) bool {
+		return *arrayMsg2.SimpleMapPtr[i].K < *arrayMsg2.SimpleMapPtr[j].K
+	})
+
 	sort.Slice(arrayMsg2.StructMap, func(i, j int) bool {
 		return arrayMsg2.StructMap[i].Key < arrayMsg2.StructMap[j].Key
 	})
@@ -0,0 +1,743 @@
+package sproto
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// Marshaler is implemented by types that encode themselves without
+// reflection, such as code generated by sprotogen -marshal.
+type Marshaler interface {
+	// MarshalSproto appends the encoded message to dst and returns the extended buffer.
+	MarshalSproto(dst []byte) ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves without
+// reflection. They ignore DecodeOptions unless they implement
+// ReaderUnmarshaler as well.
+type Unmarshaler interface {
+	// UnmarshalSproto decodes one message from data and returns the number of bytes used.
+	UnmarshalSproto(data []byte) (int, error)
+}
+
+// ReaderUnmarshaler is implemented by types that decode themselves with a
+// MessageReader, such as code generated by sprotogen -marshal. Decode reports
+// their errors with the path of fields, which are named by tag, e.g.
+// Person.#3[0]. Decode passes its DecodeOptions through the reader as well.
+type ReaderUnmarshaler interface {
+	// UnmarshalSprotoReader resets the message and decodes it from r.
+	UnmarshalSprotoReader(r *MessageReader) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+	readerUnmarshalerType = reflect.TypeOf((*ReaderUnmarshaler)(nil)).Elem()
+)
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, uint8(v), uint8(v>>8), uint8(v>>16), uint8(v>>24))
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return append(dst, uint8(v), uint8(v>>8), uint8(v>>16), uint8(v>>24),
+		uint8(v>>32), uint8(v>>40), uint8(v>>48), uint8(v>>56))
+}
+
+// MessageWriter encodes one message in place, it's the building block of
+// Marshaler implementations. Fields must be written in ascending tag order:
+//
+//	w := sproto.NewMessageWriter(dst, 2)
+//	w.WriteString(0, m.Name)
+//	w.WriteInt(1, m.Age)
+//	return w.Bytes(), nil
+type MessageWriter struct {
+	buf    []byte
+	start  int // offset of the message in buf
+	nhdr   int // header slots written
+	maxhdr int // header slots reserved
+	tag    int // last written tag
+}
+
+// NewMessageWriter starts a message with at most fields fields at the end of dst.
+func NewMessageWriter(dst []byte, fields int) MessageWriter {
+	// every field takes at most 2 header slots: a skip and its own
+	maxhdr := fields * 2
+	start := len(dst)
+	dst = append(dst, make([]byte, 2+maxhdr*2)...)
+	return MessageWriter{
+		buf:    dst,
+		start:  start,
+		maxhdr: maxhdr,
+		tag:    -1,
+	}
+}
+
+func (w *MessageWriter) putHeader(v uint16) {
+	if w.nhdr >= w.maxhdr {
+		panic("sproto: MessageWriter writes more fields than reserved")
+	}
+	writeUint16(w.buf[w.start+2+w.nhdr*2:], v)
+	w.nhdr++
+}
+
+func (w *MessageWriter) header(tag int, v uint16) {
+	if tag <= w.tag {
+		panic("sproto: MessageWriter writes fields out of tag order")
+	}
+	if skip := skipTag(w.tag, tag); skip > 0 {
+		w.putHeader(skip)
+	}
+	w.putHeader(v)
+	w.tag = tag
+}
+
+// data part of a field, returns offset of the length to be patched by endData
+func (w *MessageWriter) beginData(tag int) int {
+	w.header(tag, 0)
+	offset := len(w.buf)
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	return offset
+}
+
+func (w *MessageWriter) endData(offset int) {
+	writeUint32(w.buf[offset:], uint32(len(w.buf)-offset-4))
+}
+
+// WriteBool writes a boolean field.
+func (w *MessageWriter) WriteBool(tag int, v bool) {
+	if v {
+		w.header(tag, 4)
+	} else {
+		w.header(tag, 2)
+	}
+}
+
+// WriteInt writes an integer field.
+func (w *MessageWriter) WriteInt(tag int, v int64) {
+	if v >= 0 && v <= MaxEmbeddedInt {
+		w.header(tag, uint16(2*(v+1)))
+		return
+	}
+	w.header(tag, 0)
+	if v >= MinInt32 && v <= MaxInt32 {
+		w.buf = appendUint32(w.buf, 4)
+		w.buf = appendUint32(w.buf, uint32(v))
+	} else {
+		w.buf = appendUint32(w.buf, 8)
+		w.buf = appendUint64(w.buf, uint64(v))
+	}
+}
+
+// WriteUint writes an integer field from an unsigned value.
+func (w *MessageWriter) WriteUint(tag int, v uint64) {
+	if v <= MaxEmbeddedInt {
+		w.header(tag, uint16(2*(v+1)))
+		return
+	}
+	w.header(tag, 0)
+	if v <= MaxInt32 {
+		w.buf = appendUint32(w.buf, 4)
+		w.buf = appendUint32(w.buf, uint32(v))
+	} else {
+		w.buf = appendUint32(w.buf, 8)
+		w.buf = appendUint64(w.buf, v)
+	}
+}
+
+// WriteDouble writes a double field.
+func (w *MessageWriter) WriteDouble(tag int, v float64) {
+	w.header(tag, 0)
+	w.buf = appendUint32(w.buf, uint32(DOUBLE_SZ))
+	w.buf = appendUint64(w.buf, math.Float64bits(v))
+}
+
+// WriteString writes a string field.
+func (w *MessageWriter) WriteString(tag int, v string) {
+	w.header(tag, 0)
+	w.buf = appendUint32(w.buf, uint32(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// WriteBytes writes a binary field.
+func (w *MessageWriter) WriteBytes(tag int, v []byte) {
+	w.header(tag, 0)
+	w.buf = appendUint32(w.buf, uint32(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// WriteStruct writes a struct field, sp is a pointer to struct. Marshalers
+// encode themselves, other types are encoded by reflection.
+func (w *MessageWriter) WriteStruct(tag int, sp interface{}) error {
+	offset := w.beginData(tag)
+	if err := w.appendStruct(sp); err != nil {
+		return err
+	}
+	w.endData(offset)
+	return nil
+}
+
+func (w *MessageWriter) appendStruct(sp interface{}) (err error) {
+	if m, ok := sp.(Marshaler); ok {
+		w.buf, err = m.MarshalSproto(w.buf)
+		return
+	}
+	w.buf, err = EncodeAppend(w.buf, sp)
+	return
+}
+
+// WriteBoolSlice writes an array of boolean.
+func (w *MessageWriter) WriteBoolSlice(tag int, v []bool) {
+	offset := w.beginData(tag)
+	for _, b := range v {
+		if b {
+			w.buf = append(w.buf, 1)
+		} else {
+			w.buf = append(w.buf, 0)
+		}
+	}
+	w.endData(offset)
+}
+
+// WriteInt64Slice writes an array of integer.
+func (w *MessageWriter) WriteInt64Slice(tag int, v []int64) {
+	offset := w.beginData(tag)
+	w.buf = appendInt64Slice(w.buf, v)
+	w.endData(offset)
+}
+
+func appendInt64Slice(dst []byte, v []int64) []byte {
+	if len(v) == 0 {
+		return dst
+	}
+	intLen := 4
+	for _, n := range v {
+		if n < MinInt32 || n > MaxInt32 {
+			intLen = 8
+			break
+		}
+	}
+	dst = append(dst, uint8(intLen))
+	for _, n := range v {
+		if intLen == 4 {
+			dst = appendUint32(dst, uint32(n))
+		} else {
+			dst = appendUint64(dst, uint64(n))
+		}
+	}
+	return dst
+}
+
+// WriteDecimal writes an integer(n) field, v is scaled by 10^n and rounded.
+func (w *MessageWriter) WriteDecimal(tag int, v float64, n int) error {
+	i, err := decimalToInt(v, n)
+	if err != nil {
+		return err
+	}
+	w.WriteInt(tag, i)
+	return nil
+}
+
+// WriteDecimalSlice writes an array of integer(n).
+func (w *MessageWriter) WriteDecimalSlice(tag int, v []float64, n int) error {
+	vals := make([]int64, len(v))
+	for i, d := range v {
+		var err error
+		if vals[i], err = decimalToInt(d, n); err != nil {
+			return err
+		}
+	}
+	w.WriteInt64Slice(tag, vals)
+	return nil
+}
+
+// WriteDoubleSlice writes an array of double.
+func (w *MessageWriter) WriteDoubleSlice(tag int, v []float64) {
+	offset := w.beginData(tag)
+	w.buf = append(w.buf, uint8(DOUBLE_SZ))
+	for _, d := range v {
+		w.buf = appendUint64(w.buf, math.Float64bits(d))
+	}
+	w.endData(offset)
+}
+
+// WriteStringSlice writes an array of string.
+func (w *MessageWriter) WriteStringSlice(tag int, v []string) {
+	offset := w.beginData(tag)
+	for _, s := range v {
+		w.buf = appendUint32(w.buf, uint32(len(s)))
+		w.buf = append(w.buf, s...)
+	}
+	w.endData(offset)
+}
+
+// WriteBytesSlice writes an array of binary.
+func (w *MessageWriter) WriteBytesSlice(tag int, v [][]byte) {
+	offset := w.beginData(tag)
+	for _, b := range v {
+		w.buf = appendUint32(w.buf, uint32(len(b)))
+		w.buf = append(w.buf, b...)
+	}
+	w.endData(offset)
+}
+
+// BeginArray starts an array of struct, elements are appended by WriteElem
+// and the array is finished by EndArray with the returned offset.
+func (w *MessageWriter) BeginArray(tag int) int {
+	return w.beginData(tag)
+}
+
+// WriteElem appends one element to the array started by BeginArray.
+func (w *MessageWriter) WriteElem(sp interface{}) error {
+	offset := len(w.buf)
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	if err := w.appendStruct(sp); err != nil {
+		return err
+	}
+	w.endData(offset)
+	return nil
+}
+
+// EndArray finishes the array started by BeginArray.
+func (w *MessageWriter) EndArray(offset int) {
+	w.endData(offset)
+}
+
+// Bytes finishes the message and returns the extended buffer.
+func (w *MessageWriter) Bytes() []byte {
+	writeUint16(w.buf[w.start:], uint16(w.nhdr))
+	header := w.start + 2 + w.nhdr*2
+	reserved := w.start + 2 + w.maxhdr*2
+	n := copy(w.buf[header:], w.buf[reserved:])
+	return w.buf[:header+n]
+}
+
+// MessageReader iterates the fields of one message, it's the building block
+// of ReaderUnmarshaler implementations:
+//
+//	func (m *Person) UnmarshalSprotoReader(r *sproto.MessageReader) error {
+//		*m = Person{}
+//		for r.Next() {
+//			switch r.Tag() {
+//			case 0:
+//				v, err := r.ReadString()
+//				if err != nil {
+//					return err
+//				}
+//				m.Name = &v
+//			default:
+//				if err := r.SkipUnknown(); err != nil {
+//					return err
+//				}
+//			}
+//		}
+//		return r.Err()
+//	}
+//
+// Errors of a MessageReader are *DecodeError. Limits and other options of the
+// decoding apply to its reads.
+type MessageReader struct {
+	d      *decodeState
+	typ    reflect.Type // decoded struct type, nil if unknown
+	base   int          // offset of the message in the decoded data
+	data   []byte
+	fn     int // number of header slots
+	i      int // next header slot
+	next   int // tag of the next field
+	offset int // offset of the next data chunk
+
+	tag    int
+	val    int    // embedded value, -1 if the value is in chunk
+	chunk  []byte // data part of the current field
+	valOff int    // offset of the current value in the message
+	err    error
+}
+
+// NewMessageReader starts reading the message at the beginning of data with
+// default options.
+func NewMessageReader(data []byte) (MessageReader, error) {
+	return newMessageReader(&decodeState{opts: &DecodeOptions{}}, data)
+}
+
+func newMessageReader(d *decodeState, data []byte) (MessageReader, error) {
+	if len(data) < 2 {
+		return MessageReader{}, decodeErr(0, errTruncatedHeader)
+	}
+	fn := int(readUint16(data))
+	if d.opts.MaxFields > 0 && fn > d.opts.MaxFields {
+		return MessageReader{}, decodeErr(0, ErrFieldsLimit)
+	}
+	offset := 2 + fn*2
+	if len(data) < offset {
+		return MessageReader{}, decodeErr(0, errTruncatedHeader)
+	}
+	return MessageReader{
+		d:      d,
+		base:   d.offset,
+		data:   data,
+		fn:     fn,
+		offset: offset,
+		tag:    -1,
+	}, nil
+}
+
+// Next advances to the next field, it returns false at the end of the message or on error.
+func (r *MessageReader) Next() bool {
+	for r.err == nil && r.i < r.fn {
+		v := readUint16(r.data[2+r.i*2:])
+		r.i++
+		if v%2 != 0 { // skip tag
+			r.next += int(v+1) / 2
+			continue
+		}
+		r.tag = r.next
+		r.next++
+		if v != 0 {
+			r.val = int(v/2 - 1)
+			r.chunk = nil
+			r.valOff = r.i * 2
+			return true
+		}
+		used, chunk, err := readChunk(r.data[r.offset:])
+		if err != nil {
+			r.err = errAt(err, r.offset)
+			return false
+		}
+		r.valOff = r.offset + 4
+		r.offset += used
+		r.val = -1
+		r.chunk = chunk
+		return true
+	}
+	return false
+}
+
+// Tag returns the tag of the current field.
+func (r *MessageReader) Tag() int {
+	return r.tag
+}
+
+// Err returns the error met by Next.
+func (r *MessageReader) Err() error {
+	return r.err
+}
+
+// Used returns the number of bytes of the message, valid after Next returns false.
+func (r *MessageReader) Used() int {
+	return r.offset
+}
+
+// fail returns an error of the current value
+func (r *MessageReader) fail(reason error) error {
+	return decodeErr(r.valOff, reason)
+}
+
+// SkipUnknown handles the current field unknown to the message by
+// DecodeOptions.UnknownTags. Readers don't preserve unknown fields, they're
+// dropped with UnknownPreserve.
+func (r *MessageReader) SkipUnknown() error {
+	opts := r.d.opts
+	switch {
+	case opts.UnknownTags == UnknownError:
+		return r.fail(ErrUnknownTag)
+	case opts.UnknownTags == UnknownCallback && opts.OnUnknownTag != nil:
+		return opts.OnUnknownTag(r.typ, UnknownField{Tag: r.tag, Value: r.val, Data: r.chunk})
+	}
+	return nil
+}
+
+func (r *MessageReader) readInt() (uint64, int, error) {
+	if r.val >= 0 {
+		return uint64(r.val), 0, nil
+	}
+	switch len(r.chunk) {
+	case 0:
+		return 0, 0, nil
+	case 4:
+		return uint64(readUint32(r.chunk)), 4, nil
+	case 8:
+		return readUint64(r.chunk), 8, nil
+	}
+	return 0, 0, r.fail(errMalformedInt)
+}
+
+// ReadInt reads the current field as integer.
+func (r *MessageReader) ReadInt() (int64, error) {
+	n, sz, err := r.readInt()
+	if sz == 4 {
+		return int64(int32(n)), err
+	}
+	return int64(n), err
+}
+
+// ReadUint reads the current field as unsigned integer.
+func (r *MessageReader) ReadUint() (uint64, error) {
+	n, _, err := r.readInt()
+	return n, err
+}
+
+// ReadBool reads the current field as boolean.
+func (r *MessageReader) ReadBool() (bool, error) {
+	if r.val < 0 {
+		return false, r.fail(errMalformedBool)
+	}
+	return r.val != 0, nil
+}
+
+// ReadDouble reads the current field as double.
+func (r *MessageReader) ReadDouble() (float64, error) {
+	if r.val >= 0 || len(r.chunk) != DOUBLE_SZ {
+		return 0, r.fail(errMalformedDouble)
+	}
+	return math.Float64frombits(readUint64(r.chunk)), nil
+}
+
+// ReadString reads the current field as string.
+func (r *MessageReader) ReadString() (string, error) {
+	if r.val >= 0 {
+		return "", r.fail(errEmbeddedValue)
+	}
+	str, err := r.d.string(r.chunk)
+	return str, errAt(err, r.valOff)
+}
+
+// ReadBytes reads the current field as binary, it aliases data with
+// DecodeOptions.ZeroCopy.
+func (r *MessageReader) ReadBytes() ([]byte, error) {
+	if r.val >= 0 {
+		return nil, r.fail(errEmbeddedValue)
+	}
+	buf, err := r.d.bytes(r.chunk)
+	return buf, errAt(err, r.valOff)
+}
+
+// ReadStruct reads the current field into sp, a pointer to struct.
+// Unmarshalers decode themselves, other types are decoded by reflection.
+func (r *MessageReader) ReadStruct(sp interface{}) error {
+	if r.val >= 0 {
+		return r.fail(errEmbeddedValue)
+	}
+	return r.readElem(r.chunk, r.valOff, -1, sp)
+}
+
+// readElem decodes a struct at offset of the message, index is of the array
+// element or -1
+func (r *MessageReader) readElem(data []byte, offset int, index int, sp interface{}) error {
+	d := r.d
+	d.path = append(d.path, pathElem{name: fmt.Sprintf("#%d", r.tag), index: index})
+	d.offset = r.base + offset
+	err := decodeElem(d, data, sp)
+	d.path = d.path[:len(d.path)-1]
+	d.offset = r.base
+	return errAt(err, offset)
+}
+
+func decodeElem(d *decodeState, data []byte, sp interface{}) error {
+	if len(data) == 0 {
+		return decodeErr(0, errMalformedStruct)
+	}
+	var used int
+	var err error
+	switch u := sp.(type) {
+	case ReaderUnmarshaler:
+		used, err = decodeReader(d, data, u)
+	case Unmarshaler:
+		used, err = u.UnmarshalSproto(data)
+	default:
+		var t reflect.Type
+		var v reflect.Value
+		var st *SprotoType
+		if t, v, err = getbase(sp); err != nil {
+			return err
+		}
+		if st, err = GetSprotoType(t.Elem()); err != nil {
+			return err
+		}
+		v.Elem().Set(reflect.Zero(t.Elem()))
+		used, err = decodeMessage(d, data, st, v)
+	}
+	if err != nil {
+		return err
+	}
+	if used != len(data) {
+		return decodeErr(0, errMalformedStruct)
+	}
+	return nil
+}
+
+// ReadBoolSlice reads the current field as array of boolean.
+func (r *MessageReader) ReadBoolSlice() ([]bool, error) {
+	if r.val >= 0 {
+		return nil, r.fail(errEmbeddedValue)
+	}
+	if err := r.d.checkLen(len(r.chunk), 1); err != nil {
+		return nil, errAt(err, r.valOff)
+	}
+	vals := make([]bool, len(r.chunk))
+	for i, b := range r.chunk {
+		vals[i] = b != 0
+	}
+	return vals, nil
+}
+
+// ReadInt64Slice reads the current field as array of integer.
+func (r *MessageReader) ReadInt64Slice() ([]int64, error) {
+	if r.val >= 0 {
+		return nil, r.fail(errEmbeddedValue)
+	}
+	if len(r.chunk) > 0 {
+		if err := r.d.checkLen((len(r.chunk)-1)/4, 8); err != nil {
+			return nil, errAt(err, r.valOff)
+		}
+	}
+	vals, err := readInt64Slice(r.chunk)
+	if err != nil {
+		return nil, r.fail(err)
+	}
+	return vals, nil
+}
+
+func readInt64Slice(data []byte) ([]int64, error) {
+	if len(data) == 0 {
+		return []int64{}, nil
+	}
+	intLen := int(data[0])
+	data = data[1:]
+	if (intLen != 4 && intLen != 8) || len(data)%intLen != 0 {
+		return nil, errMalformedInt
+	}
+	vals := make([]int64, len(data)/intLen)
+	for i := range vals {
+		if intLen == 4 {
+			vals[i] = int64(int32(readUint32(data[i*4:])))
+		} else {
+			vals[i] = int64(readUint64(data[i*8:]))
+		}
+	}
+	return vals, nil
+}
+
+// ReadDecimal reads the current field as integer(n).
+func (r *MessageReader) ReadDecimal(n int) (float64, error) {
+	i, err := r.ReadInt()
+	return decimalFromInt(i, n), err
+}
+
+// ReadDecimalSlice reads the current field as array of integer(n).
+func (r *MessageReader) ReadDecimalSlice(n int) ([]float64, error) {
+	ints, err := r.ReadInt64Slice()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]float64, len(ints))
+	for i, v := range ints {
+		vals[i] = decimalFromInt(v, n)
+	}
+	return vals, nil
+}
+
+// ReadDoubleSlice reads the current field as array of double.
+func (r *MessageReader) ReadDoubleSlice() ([]float64, error) {
+	if r.val >= 0 || len(r.chunk) < 1 || int(r.chunk[0]) != DOUBLE_SZ || (len(r.chunk)-1)%DOUBLE_SZ != 0 {
+		return nil, r.fail(errMalformedDouble)
+	}
+	data := r.chunk[1:]
+	if err := r.d.checkLen(len(data)/DOUBLE_SZ, 8); err != nil {
+		return nil, errAt(err, r.valOff)
+	}
+	vals := make([]float64, len(data)/DOUBLE_SZ)
+	for i := range vals {
+		vals[i] = math.Float64frombits(readUint64(data[i*DOUBLE_SZ:]))
+	}
+	return vals, nil
+}
+
+// ReadStringSlice reads the current field as array of string.
+func (r *MessageReader) ReadStringSlice() ([]string, error) {
+	arr, err := r.ReadArray()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]string, 0, 16)
+	for arr.Next() {
+		str, err := r.d.string(arr.elem)
+		if err != nil {
+			return nil, errAt(err, arr.elemOff)
+		}
+		vals = append(vals, str)
+	}
+	return vals, arr.Err()
+}
+
+// ReadBytesSlice reads the current field as array of binary.
+func (r *MessageReader) ReadBytesSlice() ([][]byte, error) {
+	arr, err := r.ReadArray()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([][]byte, 0, 16)
+	for arr.Next() {
+		buf, err := r.d.bytes(arr.elem)
+		if err != nil {
+			return nil, errAt(err, arr.elemOff)
+		}
+		vals = append(vals, buf)
+	}
+	return vals, arr.Err()
+}
+
+// ReadArray reads the current field as array of struct.
+func (r *MessageReader) ReadArray() (ArrayReader, error) {
+	if r.val >= 0 {
+		return ArrayReader{}, r.fail(errEmbeddedValue)
+	}
+	return ArrayReader{r: r, data: r.chunk, offset: r.valOff, index: -1}, nil
+}
+
+// ArrayReader iterates the elements of an array of struct, Next fails with
+// ErrArrayLimit beyond DecodeOptions.MaxArrayLen elements.
+type ArrayReader struct {
+	r       *MessageReader
+	data    []byte
+	offset  int // offset of data in the message
+	elem    []byte
+	elemOff int // offset of elem in the message
+	index   int
+	err     error
+}
+
+// Next advances to the next element, it returns false at the end of the array or on error.
+func (a *ArrayReader) Next() bool {
+	if a.err != nil || len(a.data) == 0 {
+		return false
+	}
+	used, elem, err := readChunk(a.data)
+	if err != nil {
+		a.err = errAt(err, a.offset)
+		return false
+	}
+	a.index++
+	// elements are counted as pointers
+	if err = a.r.d.checkElemSize(a.index, int(unsafe.Sizeof(uintptr(0)))); err != nil {
+		a.err = errAt(err, a.offset)
+		return false
+	}
+	a.elemOff = a.offset + 4
+	a.data = a.data[used:]
+	a.offset += used
+	a.elem = elem
+	return true
+}
+
+// ReadStruct reads the current element into sp, a pointer to struct.
+func (a *ArrayReader) ReadStruct(sp interface{}) error {
+	return a.r.readElem(a.elem, a.elemOff, a.index, sp)
+}
+
+// Err returns the error met by Next.
+func (a *ArrayReader) Err() error {
+	return a.err
+}
@@ -0,0 +1,269 @@
+package sproto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// fastHuman is Human with hand written marshalers
+type fastHuman struct {
+	Name     *string
+	Age      *int
+	Marital  *bool
+	Children []*fastHuman
+}
+
+func (h *fastHuman) MarshalSproto(dst []byte) ([]byte, error) {
+	w := NewMessageWriter(dst, 4)
+	if h == nil {
+		return w.Bytes(), nil
+	}
+	if h.Name != nil {
+		w.WriteString(0, *h.Name)
+	}
+	if h.Age != nil {
+		w.WriteInt(1, int64(*h.Age))
+	}
+	if h.Marital != nil {
+		w.WriteBool(2, *h.Marital)
+	}
+	if h.Children != nil {
+		offset := w.BeginArray(3)
+		for _, child := range h.Children {
+			if err := w.WriteElem(child); err != nil {
+				return dst, err
+			}
+		}
+		w.EndArray(offset)
+	}
+	return w.Bytes(), nil
+}
+
+func (h *fastHuman) UnmarshalSproto(data []byte) (int, error) {
+	*h = fastHuman{}
+	r, err := NewMessageReader(data)
+	if err != nil {
+		return 0, err
+	}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			v, err := r.ReadString()
+			if err != nil {
+				return 0, err
+			}
+			h.Name = &v
+		case 1:
+			v, err := r.ReadInt()
+			if err != nil {
+				return 0, err
+			}
+			h.Age = Int(int(v))
+		case 2:
+			v, err := r.ReadBool()
+			if err != nil {
+				return 0, err
+			}
+			h.Marital = &v
+		case 3:
+			arr, err := r.ReadArray()
+			if err != nil {
+				return 0, err
+			}
+			h.Children = make([]*fastHuman, 0)
+			for arr.Next() {
+				child := new(fastHuman)
+				if err := arr.ReadStruct(child); err != nil {
+					return 0, err
+				}
+				h.Children = append(h.Children, child)
+			}
+			if err := arr.Err(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+	return r.Used(), nil
+}
+
+func toFastHuman(h *Human) *fastHuman {
+	if h == nil {
+		return nil
+	}
+	f := &fastHuman{
+		Name:    h.Name,
+		Age:     h.Age,
+		Marital: h.Marital,
+	}
+	if h.Children != nil {
+		f.Children = make([]*fastHuman, len(h.Children))
+		for i, child := range h.Children {
+			f.Children[i] = toFastHuman(child)
+		}
+	}
+	return f
+}
+
+// fastData is Data with hand written marshalers
+type fastData Data
+
+func (d *fastData) MarshalSproto(dst []byte) ([]byte, error) {
+	w := NewMessageWriter(dst, 8)
+	if d.Numbers != nil {
+		w.WriteInt64Slice(0, d.Numbers)
+	}
+	if d.Bools != nil {
+		w.WriteBoolSlice(1, d.Bools)
+	}
+	if d.Number != nil {
+		w.WriteInt(2, int64(*d.Number))
+	}
+	if d.BigNumber != nil {
+		w.WriteInt(3, *d.BigNumber)
+	}
+	if d.Double != nil {
+		w.WriteDouble(4, *d.Double)
+	}
+	if d.Doubles != nil {
+		w.WriteDoubleSlice(5, d.Doubles)
+	}
+	if d.Strings != nil {
+		w.WriteStringSlice(7, d.Strings)
+	}
+	if d.Bytes != nil {
+		w.WriteBytes(8, d.Bytes)
+	}
+	return w.Bytes(), nil
+}
+
+func (d *fastData) UnmarshalSproto(data []byte) (int, error) {
+	*d = fastData{}
+	r, err := NewMessageReader(data)
+	if err != nil {
+		return 0, err
+	}
+	for r.Next() {
+		switch r.Tag() {
+		case 0:
+			d.Numbers, err = r.ReadInt64Slice()
+		case 1:
+			d.Bools, err = r.ReadBoolSlice()
+		case 2:
+			var v int64
+			v, err = r.ReadInt()
+			d.Number = Int(int(v))
+		case 3:
+			var v int64
+			v, err = r.ReadInt()
+			d.BigNumber = &v
+		case 4:
+			var v float64
+			v, err = r.ReadDouble()
+			d.Double = &v
+		case 5:
+			d.Doubles, err = r.ReadDoubleSlice()
+		case 7:
+			d.Strings, err = r.ReadStringSlice()
+		case 8:
+			d.Bytes, err = r.ReadBytes()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+	return r.Used(), nil
+}
+
+func TestMarshaler(t *testing.T) {
+	for _, tc := range testCases {
+		var sp interface{}
+		switch v := tc.Struct.(type) {
+		case *Human:
+			sp = toFastHuman(v)
+		case *Data:
+			sp = (*fastData)(v)
+		default:
+			continue
+		}
+		output, err := Encode(sp)
+		if err != nil {
+			t.Fatalf("test case *%s* failed with error:%s", tc.Name, err)
+		}
+		if !bytes.Equal(output, tc.Data) {
+			t.Log("encoded:", output)
+			t.Log("expected:", tc.Data)
+			t.Fatalf("test case %s failed", tc.Name)
+		}
+
+		decoded := reflect.New(reflect.TypeOf(sp).Elem()).Interface()
+		used, err := Decode(tc.Data, decoded)
+		if err != nil {
+			t.Fatalf("test case *%s* failed with error:%s", tc.Name, err)
+		}
+		if used != len(tc.Data) {
+			t.Fatalf("test case *%s* failed: data length mismatch", tc.Name)
+		}
+		if !reflect.DeepEqual(decoded, sp) {
+			t.Fatalf("test case *%s* failed: decoded %+v", tc.Name, decoded)
+		}
+	}
+}
+
+type fastHumanGroup struct {
+	Leader  *fastHuman   `sproto:"struct,0"`
+	Members []*fastHuman `sproto:"struct,1,array"`
+}
+
+type humanGroup struct {
+	Leader  *Human   `sproto:"struct,0"`
+	Members []*Human `sproto:"struct,1,array"`
+}
+
+// marshalers nested in reflection encoded struct
+func TestNestedMarshaler(t *testing.T) {
+	alice := &Human{Name: String("Alice"), Age: Int(13)}
+	bob := &Human{Name: String("Bob"), Age: Int(40), Children: []*Human{alice}}
+	group := &humanGroup{Leader: bob, Members: []*Human{alice, nil, bob}}
+	fastGroup := &fastHumanGroup{
+		Leader:  toFastHuman(bob),
+		Members: []*fastHuman{toFastHuman(alice), nil, toFastHuman(bob)},
+	}
+
+	expected := MustEncode(group)
+	output, err := Encode(fastGroup)
+	if err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Log("encoded:", output)
+		t.Log("expected:", expected)
+		t.Fatal("nested marshaler encode failed")
+	}
+
+	decoded := &fastHumanGroup{}
+	MustDecode(expected, decoded)
+	// nil element is decoded as empty struct
+	fastGroup.Members[1] = &fastHuman{}
+	if !reflect.DeepEqual(decoded, fastGroup) {
+		t.Fatalf("nested unmarshaler decode failed: %+v", decoded)
+	}
+}
+
+func TestMessageWriterAppend(t *testing.T) {
+	prefix := []byte{0xaa, 0xbb}
+	alice := toFastHuman(&Human{Name: String("Alice"), Age: Int(13), Marital: Bool(false)})
+	output, err := alice.MarshalSproto(prefix)
+	if err != nil {
+		t.Fatalf("marshal failed: %s", err)
+	}
+	if !bytes.Equal(output[:2], prefix) || !bytes.Equal(output[2:], testCases[0].Data) {
+		t.Fatalf("unexpected output: %v", output)
+	}
+}
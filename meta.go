@@ -30,9 +30,8 @@ var (
 
 type headerEncoder func(st *SprotoField, v reflect.Value) (header uint16, isNil bool)
 
-// TODO: 避免 encoder 分配内存
-type encoder func(st *SprotoField, v reflect.Value) []byte
-type decoder func(val *uint16, data []byte, st *SprotoField, v reflect.Value) error
+type encoder func(st *SprotoField, dst []byte, v reflect.Value) []byte
+type decoder func(d *decodeState, val *uint16, data []byte, st *SprotoField, v reflect.Value) error
 
 type SprotoField struct {
 	field *reflect.StructField // go StructField
@@ -43,6 +42,7 @@ type SprotoField struct {
 	KeyTag   int    // -1 表示无效值
 	ValueTag int    // -1 表示无效值
 	SubType  string // 仅当 ValueTag != 1 时有效
+	Decimal  int    // n of integer(n), 0 表示无效值
 
 	st *SprotoType // for struct types only
 
@@ -107,6 +107,12 @@ func (sf *SprotoField) parse(s string) error {
 				return fmt.Errorf("parse(%s) parse value option failed:%s", s, err)
 			}
 			sf.ValueTag = tag
+		case strings.HasPrefix(f, "decimal="):
+			n, err := strconv.Atoi(f[len("decimal="):])
+			if err != nil || n < 1 || n > MaxDecimal {
+				return fmt.Errorf("sproto: parse(%s) decimal option should be in [1, %d]", s, MaxDecimal)
+			}
+			sf.Decimal = n
 		case strings.HasPrefix(f, "subtype="):
 			sf.SubType = f[len("subtype="):]
 		default:
@@ -118,6 +124,10 @@ func (sf *SprotoField) parse(s string) error {
 		return fmt.Errorf("sproto: parse(%s) failed: KeyTag depends on Array", s)
 	}
 
+	if sf.Decimal > 0 && sf.Wire != WireVarintName {
+		return fmt.Errorf("sproto: parse(%s) failed: decimal depends on integer", s)
+	}
+
 	if sf.ValueTag != -1 {
 		if sf.KeyTag == -1 {
 			return fmt.Errorf("sproto: parse(%s) failed: ValueTag depends on KeyTag", s)
@@ -200,6 +210,7 @@ func (sf *SprotoField) initMapElemType(mapType reflect.Type, valueType reflect.T
 func (sf *SprotoField) initEncAndDec(structType reflect.Type, f *reflect.StructField) error {
 	var stype reflect.Type
 	var err error
+	var decimal bool
 	t1 := f.Type
 	if t1.Kind() == reflect.Ptr {
 		t1 = t1.Elem()
@@ -218,6 +229,14 @@ func (sf *SprotoField) initEncAndDec(structType reflect.Type, f *reflect.StructF
 		sf.dec = decodeInt
 		err = sf.assertWire(WireVarintName, false)
 	case reflect.Float64:
+		if sf.Decimal > 0 {
+			decimal = true
+			sf.headerEnc = headerEncodeDecimal
+			sf.enc = encodeDecimal
+			sf.dec = decodeDecimal
+			err = sf.assertWire(WireVarintName, false)
+			break
+		}
 		sf.headerEnc = headerEncodeDefault
 		sf.enc = encodeDouble
 		sf.dec = decodeDouble
@@ -260,6 +279,13 @@ func (sf *SprotoField) initEncAndDec(structType reflect.Type, f *reflect.StructF
 			err = sf.assertWire(WireVarintName, true)
 		case reflect.Float64:
 			sf.headerEnc = headerEncodeDefault
+			if sf.Decimal > 0 {
+				decimal = true
+				sf.enc = encodeDecimalSlice
+				sf.dec = decodeDecimalSlice
+				err = sf.assertWire(WireVarintName, true)
+				break
+			}
 			sf.enc = encodeDoubleSlice
 			sf.dec = decodeDoubleSlice
 			err = sf.assertWire(WireDoubleName, true)
@@ -324,6 +350,9 @@ func (sf *SprotoField) initEncAndDec(structType reflect.Type, f *reflect.StructF
 	if err != nil {
 		return err
 	}
+	if sf.Decimal > 0 && !decimal {
+		return fmt.Errorf("sproto: field(%s) decimal should be float64", sf.field.Name)
+	}
 
 	if stype != nil {
 		if sf.st, err = getSprotoTypeLocked(stype); err != nil {
@@ -357,6 +386,11 @@ type SprotoType struct {
 	Fields []*SprotoField
 	tagMap map[int]int // tag -> fileds index
 	order  []int       // list of struct field numbers in tag order
+
+	marshaler         bool  // *Type implements Marshaler
+	unmarshaler       bool  // *Type implements Unmarshaler
+	readerUnmarshaler bool  // *Type implements ReaderUnmarshaler
+	unknown           []int // index of the UnknownFields field, nil if none
 }
 
 func (st *SprotoType) Len() int { return len(st.order) }
@@ -393,6 +427,9 @@ func getSprotoTypeLocked(t reflect.Type) (*SprotoType, error) {
 	stMap[t] = st
 
 	st.Type = t
+	st.marshaler = reflect.PtrTo(t).Implements(marshalerType)
+	st.unmarshaler = reflect.PtrTo(t).Implements(unmarshalerType)
+	st.readerUnmarshaler = reflect.PtrTo(t).Implements(readerUnmarshalerType)
 	numField := t.NumField()
 	st.Fields = make([]*SprotoField, numField)
 	st.order = make([]int, numField)
@@ -401,6 +438,18 @@ func getSprotoTypeLocked(t reflect.Type) (*SprotoType, error) {
 	for i := 0; i < numField; i++ {
 		sf := new(SprotoField)
 		f := t.Field(i)
+		if f.Type == unknownFieldsType {
+			if st.unknown != nil {
+				delete(stMap, t)
+				return nil, fmt.Errorf("sproto: type(%s) has more than one UnknownFields", t.Name())
+			}
+			st.unknown = f.Index
+			sf.field = &f
+			sf.Tag = -1
+			st.Fields[i] = sf
+			st.order[i] = i
+			continue
+		}
 		if err := sf.init(t, &f); err != nil {
 			delete(stMap, t)
 			return nil, err
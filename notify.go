@@ -0,0 +1,189 @@
+package sproto
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// OnNotify subscribes fn to notifications of protocol name, which has no
+// response. fn is a function as Handle accepts, e.g.
+// func(ctx context.Context, req *FooRequest). A protocol can have many
+// subscribers, which are called in order of subscription, but not along with
+// a method registered by Register; the first error of them goes to
+// OnHandlerError. The returned unsubscribe removes fn, notifications without
+// subscribers go to OnUnknownPacket.
+func (s *Service) OnNotify(name string, fn interface{}) (unsubscribe func(), err error) {
+	protocol := s.rpc.GetProtocolByName(name)
+	if protocol == nil {
+		return nil, ErrUnknownProtocol
+	}
+	if protocol.HasResponse() {
+		return nil, fmt.Errorf("sproto: %s isn't a notification", name)
+	}
+	withContext, withError, err := protocol.matchFunc(reflect.TypeOf(fn), 0, "subscriber of "+name)
+	if err != nil {
+		return nil, err
+	}
+	fv := reflect.ValueOf(fn)
+	if fv.IsNil() {
+		return nil, fmt.Errorf("sproto: subscriber of %s is nil", name)
+	}
+
+	l := &Method{fn: fv, protocol: protocol, withContext: withContext, withError: withError}
+	s.methodMutex.Lock()
+	defer s.methodMutex.Unlock()
+	method, ok := s.methods[name]
+	if ok && method.listeners == nil {
+		return nil, fmt.Errorf("sproto:service %s has already registered", name)
+	}
+	// methods are immutable for dispatching without lock
+	subscribed := &Method{protocol: protocol, listeners: []*Method{l}}
+	if ok {
+		subscribed.listeners = append(append([]*Method{}, method.listeners...), l)
+	}
+	s.methods[name] = subscribed
+
+	var once sync.Once
+	unsubscribe = func() {
+		once.Do(func() {
+			s.unsubscribe(name, l)
+		})
+	}
+	return unsubscribe, nil
+}
+
+func (s *Service) unsubscribe(name string, l *Method) {
+	s.methodMutex.Lock()
+	defer s.methodMutex.Unlock()
+	method := s.methods[name]
+	listeners := make([]*Method, 0, len(method.listeners))
+	for _, v := range method.listeners {
+		if v != l {
+			listeners = append(listeners, v)
+		}
+	}
+	if len(listeners) == 0 {
+		delete(s.methods, name)
+		return
+	}
+	s.methods[name] = &Method{protocol: method.protocol, listeners: listeners}
+}
+
+// default packets queued for a service of Broadcaster
+const DefaultBroadcastQueueSize = 64
+
+// Broadcaster writes packets to a group of services, e.g. notifications
+// encoded once by Service.Encode are pushed to all connections. Every service
+// has a queue written by its own goroutine, so a slow peer doesn't block
+// others: a service whose queue is full is closed. Services are removed when
+// they're closed or fail to write.
+type Broadcaster struct {
+	mutex     sync.Mutex
+	queueSize int
+	services  map[*Service]*member
+}
+
+type member struct {
+	queue   chan []byte
+	removed chan struct{} // closed on removal
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		queueSize: DefaultBroadcastQueueSize,
+		services:  make(map[*Service]*member),
+	}
+}
+
+// SetQueueSize sets how many packets can be queued for a service, it applies
+// to services added later. n <= 0 means DefaultBroadcastQueueSize.
+func (b *Broadcaster) SetQueueSize(n int) {
+	if n <= 0 {
+		n = DefaultBroadcastQueueSize
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.queueSize = n
+}
+
+// Add adds s to the group, it's a no-op if s is already added.
+func (b *Broadcaster) Add(s *Service) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.services[s]; ok {
+		return
+	}
+	m := &member{
+		queue:   make(chan []byte, b.queueSize),
+		removed: make(chan struct{}),
+	}
+	b.services[s] = m
+	go b.write(s, m)
+}
+
+// write writes queued packets to s until it's removed
+func (b *Broadcaster) write(s *Service, m *member) {
+	for {
+		select {
+		case packet := <-m.queue:
+			if err := s.WritePacket(packet); err != nil {
+				b.Remove(s)
+				return
+			}
+		case <-s.Done():
+			b.Remove(s)
+			return
+		case <-m.removed:
+			return
+		}
+	}
+}
+
+// Remove removes s from the group, packets queued for it are dropped.
+func (b *Broadcaster) Remove(s *Service) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if m, ok := b.services[s]; ok {
+		close(m.removed)
+		delete(b.services, s)
+	}
+}
+
+// Len returns the number of services in the group.
+func (b *Broadcaster) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.services)
+}
+
+// Broadcast queues packet for every service without blocking, and returns
+// the number of services queued. packet shouldn't expect a response, i.e. has
+// no session, and shouldn't be modified afterwards. Closed services are
+// removed, and services whose queues are full are closed and removed.
+func (b *Broadcaster) Broadcast(packet []byte) int {
+	b.mutex.Lock()
+	n := 0
+	var slow []*Service
+	for s, m := range b.services {
+		if s.isClosed() {
+			close(m.removed)
+			delete(b.services, s)
+			continue
+		}
+		select {
+		case m.queue <- packet:
+			n++
+		default:
+			close(m.removed)
+			delete(b.services, s)
+			slow = append(slow, s)
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, s := range slow {
+		s.Close()
+	}
+	return n
+}
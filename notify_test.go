@@ -0,0 +1,187 @@
+package sproto
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOnNotify(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, protocols)
+	server, _ := NewService(rw, protocols)
+	if _, err := server.OnNotify("test.foobar", func(ctx context.Context, req *FoobarRequest) {}); err == nil {
+		t.Fatal("subscribe a protocol with response should fail")
+	}
+	if _, err := server.OnNotify("test.bar", func(ctx context.Context, req interface{}) {}); err == nil {
+		t.Fatal("subscriber of wrong arguments should fail")
+	}
+
+	var got []int
+	unsub1, err := server.OnNotify("test.bar", func() {
+		got = append(got, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsub2, _ := server.OnNotify("test.bar", func(ctx context.Context) {
+		if ProtocolFromContext(ctx).Name != "test.bar" {
+			t.Errorf("unexpected protocol:%v", ProtocolFromContext(ctx))
+		}
+		got = append(got, 2)
+	})
+	if err := server.Register(&inst); err == nil {
+		t.Fatal("register a subscribed protocol should fail")
+	}
+
+	client.Invoke("test.bar", nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	unsub1()
+	unsub1()
+	client.Invoke("test.bar", nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 2 {
+		t.Fatalf("unexpected notifications:%v", got)
+	}
+
+	unsub2()
+	client.Invoke("test.bar", nil)
+	if err := server.DispatchOnce(); err == nil {
+		t.Fatal("notification without subscribers should be unknown")
+	}
+}
+
+var notifyProtocols = []*Protocol{
+	{
+		Type:    1,
+		Name:    "notify.what",
+		Request: reflect.TypeOf(&FoobarRequest{}),
+	},
+}
+
+func TestOnNotifyTyped(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, notifyProtocols)
+	server, _ := NewService(rw, notifyProtocols)
+
+	var what string
+	server.OnNotify("notify.what", func(ctx context.Context, req *FoobarRequest) {
+		what = *req.What
+	})
+	server.OnNotify("notify.what", func(req *FoobarRequest) error {
+		return errFail
+	})
+	var handlerErr error
+	server.SetOnHandlerError(func(ctx context.Context, err error) error {
+		handlerErr = err
+		return nil
+	})
+
+	client.Invoke("notify.what", &FoobarRequest{What: String("hello")})
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if what != "hello" || handlerErr != errFail {
+		t.Fatalf("unexpected notification:%s, err:%v", what, handlerErr)
+	}
+}
+
+func TestBroadcasterQueueSize(t *testing.T) {
+	b := NewBroadcaster()
+	for _, n := range []int{0, -1} {
+		b.SetQueueSize(n)
+		if b.queueSize != DefaultBroadcastQueueSize {
+			t.Fatalf("unexpected queue size of %d:%d", n, b.queueSize)
+		}
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+	var clients, servers []*Service
+	notified := make(chan struct{}, 16)
+	for i := 0; i < 3; i++ {
+		local, remote := net.Pipe()
+		server, _ := NewService(remote, protocols)
+		client, _ := NewService(local, protocols)
+		client.OnNotify("test.bar", func() {
+			notified <- struct{}{}
+		})
+		go client.Dispatch()
+		defer client.Close()
+		b.Add(server)
+		b.Add(server)
+		clients = append(clients, client)
+		servers = append(servers, server)
+	}
+	if b.Len() != 3 {
+		t.Fatalf("unexpected services:%d", b.Len())
+	}
+
+	packet, _ := clients[0].Encode("test.bar", nil)
+	if n := b.Broadcast(packet); n != 3 {
+		t.Fatalf("unexpected written:%d", n)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-notified:
+		case <-time.After(time.Second):
+			t.Fatalf("unexpected notifications:%d", i)
+		}
+	}
+
+	// closed services are removed
+	servers[0].Close()
+	if n := b.Broadcast(packet); n != 2 || b.Len() != 2 {
+		t.Fatalf("unexpected written:%d, services:%d", n, b.Len())
+	}
+	b.Remove(servers[1])
+	if n := b.Broadcast(packet); n != 1 {
+		t.Fatalf("unexpected written:%d", n)
+	}
+}
+
+func TestBroadcasterSlowPeer(t *testing.T) {
+	b := NewBroadcaster()
+	b.SetQueueSize(1)
+
+	// the peer never reads
+	local, remote := net.Pipe()
+	defer local.Close()
+	slow, _ := NewService(remote, protocols)
+	b.Add(slow)
+
+	// a fast peer
+	fast, _ := NewService(&bytes.Buffer{}, protocols)
+	b.Add(fast)
+
+	packet, _ := slow.Encode("test.bar", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 8 && b.Len() == 2; i++ {
+			b.Broadcast(packet)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast is blocked by slow peer")
+	}
+	select {
+	case <-slow.Done():
+	case <-time.After(time.Second):
+		t.Fatal("slow peer isn't closed")
+	}
+	if b.Len() != 1 || b.Broadcast(packet) != 1 {
+		t.Fatalf("unexpected services:%d", b.Len())
+	}
+}
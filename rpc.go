@@ -1,6 +1,7 @@
 package sproto
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -11,6 +12,11 @@ var (
 	ErrRepeatedRpc     = errors.New("sproto rpc: repeated rpc")
 	ErrUnknownProtocol = errors.New("sproto rpc: unknown protocol")
 	ErrUnknownSession  = errors.New("sproto rpc: unknown session")
+	ErrServiceClosed   = errors.New("sproto rpc: service closed")
+	ErrServerClosed    = errors.New("sproto rpc: server closed")
+	ErrClientClosed    = errors.New("sproto rpc: client closed")
+	ErrHandlerPanic    = errors.New("sproto rpc: handler panic")
+	ErrNoHeaderType    = errors.New("sproto rpc: no header type set (SetHeaderType)")
 )
 
 type RpcMode int
@@ -20,9 +26,42 @@ const (
 	RpcResponseMode
 )
 
+// rpcHeader is the package header of skynet, tag 2 is reserved for its ud.
+// An error reply carries ErrCode and ErrMsg, which older peers ignore.
 type rpcHeader struct {
-	Type    *int32 `sproto:"integer,0"`
-	Session *int32 `sproto:"integer,1"`
+	Type    *int32  `sproto:"integer,0"`
+	Session *int32  `sproto:"integer,1"`
+	ErrCode *int32  `sproto:"integer,3"`
+	ErrMsg  *string `sproto:"string,4"`
+}
+
+// RemoteError is the error replied by the peer for a failed request. A
+// handler returns it to reply a specific Code and Message, other errors are
+// replied as CodeInternal without details, which stay local for
+// OnHandlerError.
+type RemoteError struct {
+	Code    int32
+	Message string
+}
+
+// CodeInternal is the Code replied for a panicking handler or an error other
+// than *RemoteError, with the message "internal error".
+const CodeInternal int32 = -1
+
+// CodeUnavailable is the Code replied for requests arriving during
+// Service.Shutdown.
+const CodeUnavailable int32 = -2
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("sproto: remote error(%d): %s", e.Code, e.Message)
+}
+
+func toRemoteError(err error) *RemoteError {
+	var re *RemoteError
+	if !errors.Is(err, ErrHandlerPanic) && errors.As(err, &re) {
+		return re
+	}
+	return &RemoteError{Code: CodeInternal, Message: "internal error"}
 }
 
 type Protocol struct {
@@ -31,6 +70,7 @@ type Protocol struct {
 	MethodName string
 	Request    reflect.Type
 	Response   reflect.Type
+	Idempotent bool // calls can be retried by Client after reconnection
 }
 
 func (p *Protocol) HasRequest() bool {
@@ -41,12 +81,42 @@ func (p *Protocol) HasResponse() bool {
 	return p.Response != nil
 }
 
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
 // func (rcvr Reciver) MethodName(req protocol.Request, response protocol.Response)
+// func (rcvr Reciver) MethodName(ctx context.Context, req protocol.Request, response protocol.Response) error
 func (p *Protocol) MatchMethod(method reflect.Method) error {
-	mtyp := method.Type
+	_, _, err := p.matchMethod(method)
+	return err
+}
 
+// matchMethod also reports whether method takes a context and returns an error
+func (p *Protocol) matchMethod(method reflect.Method) (withContext bool, withError bool, err error) {
 	// default args: rcvr
-	numIn := 1
+	return p.matchFunc(method.Type, 1, "method "+p.MethodName)
+}
+
+// func(req protocol.Request, response protocol.Response)
+// func(ctx context.Context, req protocol.Request, response protocol.Response) error
+func (p *Protocol) MatchFunc(fn reflect.Type) error {
+	_, _, err := p.matchFunc(fn, 0, "handler of "+p.Name)
+	return err
+}
+
+// matchFunc matches mtyp with its first numIn arguments skipped
+func (p *Protocol) matchFunc(mtyp reflect.Type, numIn int, what string) (withContext bool, withError bool, err error) {
+	if mtyp == nil || mtyp.Kind() != reflect.Func {
+		err = fmt.Errorf("sproto: %s should be a function", what)
+		return
+	}
+	if mtyp.NumIn() > numIn && mtyp.In(numIn) == contextType {
+		withContext = true
+		numIn += 1
+	}
+	first := numIn
 	if p.Request != nil {
 		numIn += 1
 	}
@@ -55,22 +125,29 @@ func (p *Protocol) MatchMethod(method reflect.Method) error {
 		numIn += 1
 	}
 
-	if mtyp.NumIn() != numIn || mtyp.NumOut() != 0 {
-		return fmt.Errorf("sproto: method %s should have %d arguments and 0 return values", p.MethodName, numIn)
+	if mtyp.NumOut() == 1 && mtyp.Out(0) == errorType {
+		withError = true
+	}
+
+	if mtyp.NumIn() != numIn || (mtyp.NumOut() != 0 && !withError) {
+		err = fmt.Errorf("sproto: %s should have %d arguments and 0 return values or an error", what, numIn)
+		return
 	}
 
 	if p.Request != nil {
-		if mtyp.In(1) != p.Request {
-			return fmt.Errorf("sproto: method %s arg%d should be %s", p.MethodName, 1, p.Request.String())
+		if mtyp.In(first) != p.Request {
+			err = fmt.Errorf("sproto: %s arg%d should be %s", what, first, p.Request.String())
+			return
 		}
 	}
 
 	if p.Response != nil {
 		if mtyp.In(numIn-1) != p.Response {
-			return fmt.Errorf("sproto: method %s arg%d should be %s", p.MethodName, 2, p.Response.String())
+			err = fmt.Errorf("sproto: %s arg%d should be %s", what, numIn-1, p.Response.String())
+			return
 		}
 	}
-	return nil
+	return
 }
 
 type Rpc struct {
@@ -79,7 +156,14 @@ type Rpc struct {
 	nameMap      map[string]int
 	methodMap    map[string]int
 	sessionMutex sync.Mutex
-	sessions     map[int32]int
+	sessions     map[int32]int // session -> index of remote.protocols
+	decodeOpts   DecodeOptions
+	remote       *Rpc // protocols of requests to the peer, rpc itself by default
+
+	// custom package header, nil for rpcHeader
+	headerType   reflect.Type
+	typeIndex    []int
+	sessionIndex []int
 }
 
 func getRpcSprotoType(typ reflect.Type) (*SprotoType, error) {
@@ -95,6 +179,13 @@ func getRpcSprotoType(typ reflect.Type) (*SprotoType, error) {
 }
 
 func (rpc *Rpc) Dispatch(packed []byte) (mode RpcMode, name string, session int32, sp interface{}, err error) {
+	mode, name, session, sp, _, err = rpc.DispatchHeader(packed)
+	return
+}
+
+// DispatchHeader is Dispatch that also returns the package header, which is
+// of the type set by SetHeaderType, or nil if it's not set.
+func (rpc *Rpc) DispatchHeader(packed []byte) (mode RpcMode, name string, session int32, sp interface{}, h interface{}, err error) {
 	var unpacked []byte
 	if unpacked, err = Unpack(packed); err != nil {
 		return
@@ -105,6 +196,13 @@ func (rpc *Rpc) Dispatch(packed []byte) (mode RpcMode, name string, session int3
 	if used, err = Decode(unpacked, &header); err != nil {
 		return
 	}
+	// error replies carry no custom header, see ErrorEncode
+	if rpc.headerType != nil && header.ErrCode == nil && header.ErrMsg == nil {
+		h = reflect.New(rpc.headerType.Elem()).Interface()
+		if _, err = rpc.decodeOpts.Decode(unpacked, h); err != nil {
+			return
+		}
+	}
 
 	var proto *Protocol
 	if header.Type != nil {
@@ -116,7 +214,7 @@ func (rpc *Rpc) Dispatch(packed []byte) (mode RpcMode, name string, session int3
 		proto = rpc.protocols[index]
 		if proto.Request != nil {
 			sp = reflect.New(proto.Request.Elem()).Interface()
-			if _, err = Decode(unpacked[used:], sp); err != nil {
+			if _, err = rpc.decodeOpts.Decode(unpacked[used:], sp); err != nil {
 				return
 			}
 		}
@@ -125,6 +223,7 @@ func (rpc *Rpc) Dispatch(packed []byte) (mode RpcMode, name string, session int3
 			session = *header.Session
 		}
 	} else {
+		mode = RpcResponseMode
 		if header.Session == nil {
 			err = ErrUnknownSession
 			return
@@ -139,20 +238,53 @@ func (rpc *Rpc) Dispatch(packed []byte) (mode RpcMode, name string, session int3
 		}
 		delete(rpc.sessions, session)
 
-		proto = rpc.protocols[index]
-		if proto.Response != nil {
+		proto = rpc.remote.protocols[index]
+		if header.ErrCode != nil || header.ErrMsg != nil {
+			re := &RemoteError{}
+			if header.ErrCode != nil {
+				re.Code = *header.ErrCode
+			}
+			if header.ErrMsg != nil {
+				re.Message = *header.ErrMsg
+			}
+			sp = re
+		} else if proto.Response != nil {
 			sp = reflect.New(proto.Response.Elem()).Interface()
-			if _, err = Decode(unpacked[used:], sp); err != nil {
+			if _, err = rpc.decodeOpts.Decode(unpacked[used:], sp); err != nil {
 				return
 			}
 		}
-		mode = RpcResponseMode
 	}
 	name = proto.Name
 	return
 }
 
+// ErrorEncode encodes an error reply of session, which Dispatch of the peer
+// returns as sp of type *RemoteError. Its package header has only session and
+// the error, fields of a custom header are dropped and DispatchHeader returns
+// a nil header for it.
+func (rpc *Rpc) ErrorEncode(name string, session int32, re *RemoteError) (data []byte, err error) {
+	if _, ok := rpc.nameMap[name]; !ok {
+		err = ErrUnknownProtocol
+		return
+	}
+	header, _ := Encode(&rpcHeader{
+		Session: &session,
+		ErrCode: &re.Code,
+		ErrMsg:  &re.Message,
+	})
+	data = Pack(header)
+	return
+}
+
 func (rpc *Rpc) ResponseEncode(name string, session int32, response interface{}) (data []byte, err error) {
+	return rpc.ResponseEncodeHeader(name, session, response, nil)
+}
+
+// ResponseEncodeHeader is ResponseEncode with the package header h, whose
+// type and session fields are filled in. h is of the type set by
+// SetHeaderType, or nil for a header of only type and session.
+func (rpc *Rpc) ResponseEncodeHeader(name string, session int32, response interface{}, h interface{}) (data []byte, err error) {
 	index, ok := rpc.nameMap[name]
 	if !ok {
 		err = ErrUnknownProtocol
@@ -166,28 +298,42 @@ func (rpc *Rpc) ResponseEncode(name string, session int32, response interface{})
 		}
 	}
 
-	header, _ := Encode(&rpcHeader{Session: &session})
+	header, err := rpc.encodeHeader(h, nil, &session)
+	if err != nil {
+		return
+	}
 	data = Pack(Append(header, data))
 	return
 }
 
 // session > 0: need response
 func (rpc *Rpc) RequestEncode(name string, session int32, req interface{}) (data []byte, err error) {
-	index, ok := rpc.nameMap[name]
+	return rpc.RequestEncodeHeader(name, session, req, nil)
+}
+
+// RequestEncodeHeader is RequestEncode with the package header h, see
+// ResponseEncodeHeader.
+func (rpc *Rpc) RequestEncodeHeader(name string, session int32, req interface{}, h interface{}) (data []byte, err error) {
+	index, ok := rpc.remote.nameMap[name]
 	if !ok {
 		err = ErrUnknownProtocol
 		return
 	}
 
-	protocol := rpc.protocols[index]
+	protocol := rpc.remote.protocols[index]
 	if protocol.Request != nil {
 		if data, err = Encode(req); err != nil {
 			return
 		}
 	}
 
-	header := &rpcHeader{
-		Type: &protocol.Type,
+	var psession *int32
+	if protocol.HasResponse() {
+		psession = &session
+	}
+	chunk, err := rpc.encodeHeader(h, &protocol.Type, psession)
+	if err != nil {
+		return
 	}
 
 	if protocol.HasResponse() {
@@ -197,15 +343,75 @@ func (rpc *Rpc) RequestEncode(name string, session int32, req interface{}) (data
 			err = fmt.Errorf("sproto: repeated session:%d", session)
 			return
 		}
-		header.Session = &session
 		rpc.sessions[session] = index
 	}
 
-	chunk, _ := Encode(header)
 	data = Pack(Append(chunk, data))
 	return
 }
 
+// encodeHeader encodes a copy of h with typ and session
+func (rpc *Rpc) encodeHeader(h interface{}, typ *int32, session *int32) ([]byte, error) {
+	if h == nil {
+		return Encode(&rpcHeader{Type: typ, Session: session})
+	}
+	if rpc.headerType == nil {
+		return nil, ErrNoHeaderType
+	}
+	if reflect.TypeOf(h) != rpc.headerType {
+		return nil, fmt.Errorf("sproto rpc: header should be %v, but get %T", rpc.headerType, h)
+	}
+	if reflect.ValueOf(h).IsNil() {
+		return Encode(&rpcHeader{Type: typ, Session: session})
+	}
+	v := reflect.New(rpc.headerType.Elem())
+	v.Elem().Set(reflect.ValueOf(h).Elem())
+	v.Elem().FieldByIndex(rpc.typeIndex).Set(reflect.ValueOf(typ))
+	v.Elem().FieldByIndex(rpc.sessionIndex).Set(reflect.ValueOf(session))
+	return Encode(v.Interface())
+}
+
+// SetHeaderType sets the type of package headers, a pointer to struct with
+// *int32 fields of tag 0 and 1 for type and session, e.g. the ud of skynet
+// goes to an integer field of tag 2. Tag 3 and 4 are reserved for error replies.
+// A nil typ restores the default header. It's not safe to call during
+// Dispatch or encoding.
+func (rpc *Rpc) SetHeaderType(typ reflect.Type) error {
+	if typ == nil {
+		rpc.headerType, rpc.typeIndex, rpc.sessionIndex = nil, nil, nil
+		return nil
+	}
+	st, err := getRpcSprotoType(typ)
+	if err != nil {
+		return err
+	}
+	int32Ptr := reflect.TypeOf((*int32)(nil))
+	typeField, sessionField := st.FieldByTag(0), st.FieldByTag(1)
+	if typeField == nil || sessionField == nil || typeField.field.Type != int32Ptr || sessionField.field.Type != int32Ptr {
+		return fmt.Errorf("sproto rpc: header %v should have *int32 fields of tag 0 and 1", typ)
+	}
+	if st.FieldByTag(3) != nil || st.FieldByTag(4) != nil {
+		return fmt.Errorf("sproto rpc: header %v uses tag 3 or 4 of error replies", typ)
+	}
+	rpc.headerType = typ
+	rpc.typeIndex = typeField.field.Index
+	rpc.sessionIndex = sessionField.field.Index
+	return nil
+}
+
+// forgetSession drops a pending session whose response isn't wanted anymore
+func (rpc *Rpc) forgetSession(session int32) {
+	rpc.sessionMutex.Lock()
+	delete(rpc.sessions, session)
+	rpc.sessionMutex.Unlock()
+}
+
+// SetDecodeOptions sets options for decoding requests and responses, e.g.
+// limits against hostile packets. It's not safe to call during Dispatch.
+func (rpc *Rpc) SetDecodeOptions(opts DecodeOptions) {
+	rpc.decodeOpts = opts
+}
+
 // get protocol by method name
 func (rpc *Rpc) GetProtocolByMethod(method string) *Protocol {
 	if index, ok := rpc.methodMap[method]; ok {
@@ -253,5 +459,27 @@ func NewRpc(protocols []*Protocol) (*Rpc, error) {
 		methodMap: methodMap,
 		sessions:  make(map[int32]int),
 	}
+	rpc.remote = rpc
+	return rpc, nil
+}
+
+// NewRpcPeer returns a Rpc dispatching requests of local protocols and
+// encoding requests of remote protocols, like c2s and s2c of a sproto host
+// and its attached remote. Responses are encoded and dispatched with the
+// protocols of their requests.
+func NewRpcPeer(local, remote []*Protocol) (*Rpc, error) {
+	rpc, err := NewRpc(local)
+	if err != nil {
+		return nil, err
+	}
+	if rpc.remote, err = NewRpc(remote); err != nil {
+		return nil, err
+	}
 	return rpc, nil
 }
+
+// GetRemoteProtocolByName gets protocol of requests to the peer by name,
+// which is GetProtocolByName unless created by NewRpcPeer.
+func (rpc *Rpc) GetRemoteProtocolByName(name string) *Protocol {
+	return rpc.remote.GetProtocolByName(name)
+}
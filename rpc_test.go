@@ -123,3 +123,132 @@ func TestRpcResponse(t *testing.T) {
 		t.Fatalf("dispatch failed:unmatch data")
 	}
 }
+
+func TestRpcErrorReply(t *testing.T) {
+	client, _ := NewRpc(protocols)
+	server, _ := NewRpc(protocols)
+
+	chunk, _ := client.RequestEncode("test.foobar", 7, &FoobarRequest{What: String("hello")})
+	if _, _, _, _, err := server.Dispatch(chunk); err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := server.ErrorEncode("test.foobar", 7, &RemoteError{Code: 404, Message: "not found"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, name, session, sp, err := client.Dispatch(chunk)
+	if err != nil || mode != RpcResponseMode || name != "test.foobar" || session != 7 {
+		t.Fatalf("unexpected dispatch: %v %s %d %v", mode, name, session, err)
+	}
+	if re, ok := sp.(*RemoteError); !ok || re.Code != 404 || re.Message != "not found" {
+		t.Fatalf("unexpected reply: %v", sp)
+	}
+
+	// an older peer sees an empty response
+	var header struct {
+		Type    *int32 `sproto:"integer,0"`
+		Session *int32 `sproto:"integer,1"`
+	}
+	unpacked, _ := Unpack(chunk)
+	if _, err := Decode(unpacked, &header); err != nil || *header.Session != 7 {
+		t.Fatalf("unexpected header: %v", err)
+	}
+}
+
+type udHeader struct {
+	Type    *int32 `sproto:"integer,0"`
+	Session *int32 `sproto:"integer,1"`
+	Ud      *int32 `sproto:"integer,2"`
+}
+
+type traceHeader struct {
+	Type    *int32  `sproto:"integer,0"`
+	Session *int32  `sproto:"integer,1"`
+	Ud      *int32  `sproto:"integer,2"`
+	Trace   *string `sproto:"string,5"`
+}
+
+type errHeader struct {
+	Type    *int32  `sproto:"integer,0"`
+	Session *int32  `sproto:"integer,1"`
+	Msg     *string `sproto:"string,4"`
+}
+
+func TestRpcHeader(t *testing.T) {
+	client, _ := NewRpc(protocols)
+	server, _ := NewRpc(protocols)
+	if _, err := client.RequestEncodeHeader("test.foobar", 1, &FoobarRequest{}, &udHeader{}); err != ErrNoHeaderType {
+		t.Fatalf("expect ErrNoHeaderType, but get %v", err)
+	}
+	if err := server.SetHeaderType(reflect.TypeOf(&FoobarRequest{})); err == nil {
+		t.Fatal("header without type and session should be refused")
+	}
+	if err := server.SetHeaderType(reflect.TypeOf(&udHeader{})); err != nil {
+		t.Fatal(err)
+	}
+
+	// a peer of default header sends no ud
+	chunk, _ := client.RequestEncode("test.foobar", 1, &FoobarRequest{What: String("hello")})
+	_, _, _, _, h, err := server.DispatchHeader(chunk)
+	if err != nil || h.(*udHeader).Ud != nil || *h.(*udHeader).Session != 1 {
+		t.Fatalf("unexpected header: %+v, %v", h, err)
+	}
+	chunk, err = server.ResponseEncodeHeader("test.foobar", 1, &FoobarResponse{}, &udHeader{Ud: Int32(9)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, session, _, err := client.Dispatch(chunk); err != nil || session != 1 {
+		t.Fatalf("unexpected response: %d, %v", session, err)
+	}
+
+	client.SetHeaderType(reflect.TypeOf(&udHeader{}))
+	if _, err := client.RequestEncodeHeader("test.foobar", 2, &FoobarRequest{}, &FooResponse{}); err == nil {
+		t.Fatal("header of wrong type should be refused")
+	}
+	h = &udHeader{Ud: Int32(7), Type: Int32(100)}
+	chunk, _ = client.RequestEncodeHeader("test.foobar", 2, &FoobarRequest{}, h)
+	if *h.(*udHeader).Type != 100 || h.(*udHeader).Session != nil {
+		t.Fatal("header shouldn't be modified")
+	}
+	mode, name, session, _, h, err := server.DispatchHeader(chunk)
+	if err != nil || mode != RpcRequestMode || name != "test.foobar" || session != 2 || *h.(*udHeader).Ud != 7 {
+		t.Fatalf("unexpected request: %s %d %+v %v", name, session, h, err)
+	}
+
+	// non-pointer header
+	if _, err := client.RequestEncodeHeader("test.foobar", 3, &FoobarRequest{}, udHeader{}); err == nil {
+		t.Fatal("header of wrong type should be refused")
+	}
+	if err := server.SetHeaderType(reflect.TypeOf(&errHeader{})); err == nil {
+		t.Fatal("header of tag 4 should be refused")
+	}
+
+	// error replies carry no custom header
+	chunk, _ = server.ErrorEncode("test.foobar", 2, &RemoteError{Code: 1})
+	if _, _, _, sp, h, err := client.DispatchHeader(chunk); err != nil || h != nil || sp.(*RemoteError).Code != 1 {
+		t.Fatalf("unexpected error reply: %+v %+v %v", sp, h, err)
+	}
+}
+
+func TestRpcHeaderDecodeOptions(t *testing.T) {
+	client, _ := NewRpc(protocols)
+	server, _ := NewRpc(protocols)
+	client.SetHeaderType(reflect.TypeOf(&traceHeader{}))
+	server.SetHeaderType(reflect.TypeOf(&udHeader{}))
+	chunk, err := client.RequestEncodeHeader("test.foobar", 1, &FoobarRequest{}, &traceHeader{Trace: String("abc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, _, err := server.DispatchHeader(chunk); err != nil {
+		t.Fatal(err)
+	}
+	server.SetDecodeOptions(DecodeOptions{UnknownTags: UnknownError})
+	if _, _, _, _, _, err := server.DispatchHeader(chunk); !errors.Is(err, ErrUnknownTag) {
+		t.Fatalf("expect ErrUnknownTag, but get %v", err)
+	}
+	server.SetHeaderType(reflect.TypeOf(&traceHeader{}))
+	server.SetDecodeOptions(DecodeOptions{MaxBytesLen: 2})
+	if _, _, _, _, _, err := server.DispatchHeader(chunk); !errors.Is(err, ErrBytesLimit) {
+		t.Fatalf("expect ErrBytesLimit, but get %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+// Package schema parses sproto schema files.
+//
+// A schema file declares user types and protocols:
+//
+//	.Person {
+//		name 0 : string
+//		id 1 : integer
+//
+//		.PhoneNumber {
+//			number 0 : string
+//			type 1 : integer
+//		}
+//
+//		phone 3 : *PhoneNumber
+//		height 4 : integer(2)
+//	}
+//
+//	.Bank {
+//		clients 0 : *Person(id)
+//	}
+//
+//	foobar 1 {
+//		request Person
+//		response {
+//			ok 0 : boolean
+//		}
+//	}
+//
+// Parse turns the source text into an AST; Resolve links the type references
+// of one or more files into a Schema.
+package schema
+
+import "fmt"
+
+// Pos describes a position in a source file; Line and Column are 1-based.
+type Pos struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (p Pos) String() string {
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// Error is a parse or resolve error at a source position.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos Pos, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// File is the AST of one schema file.
+type File struct {
+	Name      string
+	Types     []*TypeDecl
+	Protocols []*ProtocolDecl
+}
+
+// TypeDecl is a user type declaration: .Name { fields... }
+type TypeDecl struct {
+	Pos    Pos
+	Name   string
+	Fields []*FieldDecl
+	Types  []*TypeDecl // nested types
+}
+
+// FieldDecl is a field declaration: name tag : [*]type[(key)]
+type FieldDecl struct {
+	Pos      Pos
+	Name     string
+	Tag      int
+	Array    bool
+	TypeName string // builtin name or (possibly dotted) user type name
+	Decimal  int    // n of integer(n), 0 if absent
+	HasKey   bool   // a main index was given: *Type(key) or *Type()
+	Key      string // empty for *Type()
+}
+
+// ProtocolDecl is a protocol declaration: name tag { request ... response ... }
+type ProtocolDecl struct {
+	Pos  Pos
+	Name string
+	Tag  int
+
+	// request/response is either a reference to a named type or an inline type.
+	RequestName  string
+	Request      *TypeDecl
+	ResponseName string
+	Response     *TypeDecl
+	ResponseNil  bool // response nil: a confirmation without content
+}
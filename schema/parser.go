@@ -0,0 +1,281 @@
+package schema
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// same limits as the sproto package
+const (
+	tagMin     = 0
+	tagMax     = 32766
+	decimalMax = 18 // 10^n of integer(n) fits in int64
+)
+
+type parser struct {
+	s *scanner
+
+	// current token
+	tok token
+	pos Pos
+	lit string
+}
+
+func (p *parser) next() error {
+	var err error
+	p.tok, p.pos, p.lit, err = p.s.scan()
+	return err
+}
+
+func (p *parser) unexpected(expected string) error {
+	found := p.tok.String()
+	if p.tok == tokIdent || p.tok == tokNumber {
+		found += " " + strconv.Quote(p.lit)
+	}
+	return errorf(p.pos, "expected %s, found %s", expected, found)
+}
+
+func (p *parser) expect(tok token) (Pos, string, error) {
+	pos, lit := p.pos, p.lit
+	if p.tok != tok {
+		return pos, lit, p.unexpected(tok.String())
+	}
+	return pos, lit, p.next()
+}
+
+// a plain name must not contain '.'
+func (p *parser) expectName(what string) (Pos, string, error) {
+	pos, lit, err := p.expect(tokIdent)
+	if err != nil {
+		return pos, lit, err
+	}
+	if strings.Contains(lit, ".") {
+		return pos, lit, errorf(pos, "invalid %s name %q", what, lit)
+	}
+	return pos, lit, nil
+}
+
+func (p *parser) expectTag() (int, error) {
+	pos, lit, err := p.expect(tokNumber)
+	if err != nil {
+		return 0, err
+	}
+	tag, err := strconv.Atoi(lit)
+	if err != nil || tag < tagMin || tag > tagMax {
+		return 0, errorf(pos, "tag %s out of range [%d, %d]", lit, tagMin, tagMax)
+	}
+	return tag, nil
+}
+
+func (p *parser) parseFile(name string) (*File, error) {
+	f := &File{Name: name}
+	for p.tok != tokEOF {
+		switch p.tok {
+		case tokDot:
+			t, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			f.Types = append(f.Types, t)
+		case tokIdent:
+			proto, err := p.parseProtocol()
+			if err != nil {
+				return nil, err
+			}
+			f.Protocols = append(f.Protocols, proto)
+		default:
+			return nil, p.unexpected("type or protocol declaration")
+		}
+	}
+	return f, nil
+}
+
+// .Name { fields and nested types }
+func (p *parser) parseType() (*TypeDecl, error) {
+	pos, _, err := p.expect(tokDot)
+	if err != nil {
+		return nil, err
+	}
+	_, name, err := p.expectName("type")
+	if err != nil {
+		return nil, err
+	}
+	t := &TypeDecl{Pos: pos, Name: name}
+	if err := p.parseTypeBody(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *parser) parseTypeBody(t *TypeDecl) error {
+	if _, _, err := p.expect(tokLBrace); err != nil {
+		return err
+	}
+	for p.tok != tokRBrace {
+		switch p.tok {
+		case tokDot:
+			nested, err := p.parseType()
+			if err != nil {
+				return err
+			}
+			t.Types = append(t.Types, nested)
+		case tokIdent:
+			f, err := p.parseField()
+			if err != nil {
+				return err
+			}
+			t.Fields = append(t.Fields, f)
+		default:
+			return p.unexpected("field or type declaration")
+		}
+	}
+	return p.next()
+}
+
+// name tag : [*]type[(key)]
+func (p *parser) parseField() (*FieldDecl, error) {
+	pos, name, err := p.expectName("field")
+	if err != nil {
+		return nil, err
+	}
+	f := &FieldDecl{Pos: pos, Name: name}
+	if f.Tag, err = p.expectTag(); err != nil {
+		return nil, err
+	}
+	if _, _, err = p.expect(tokColon); err != nil {
+		return nil, err
+	}
+	if p.tok == tokStar {
+		f.Array = true
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, f.TypeName, err = p.expect(tokIdent); err != nil {
+		return nil, err
+	}
+	if p.tok != tokLParen {
+		return f, nil
+	}
+
+	lpos := p.pos
+	if err = p.next(); err != nil {
+		return nil, err
+	}
+	switch {
+	case f.TypeName == "integer":
+		npos, lit, err := p.expect(tokNumber)
+		if err != nil {
+			return nil, err
+		}
+		if f.Decimal, err = strconv.Atoi(lit); err != nil || f.Decimal <= 0 || f.Decimal > decimalMax {
+			return nil, errorf(npos, "decimal precision %s out of range [1, %d]", lit, decimalMax)
+		}
+	case f.Array:
+		f.HasKey = true
+		if p.tok == tokIdent {
+			if _, f.Key, err = p.expectName("key"); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errorf(lpos, "main index is only allowed on array field %s", name)
+	}
+	if _, _, err = p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// name tag { request ... response ... }
+func (p *parser) parseProtocol() (*ProtocolDecl, error) {
+	pos, name, err := p.expectName("protocol")
+	if err != nil {
+		return nil, err
+	}
+	proto := &ProtocolDecl{Pos: pos, Name: name}
+	if proto.Tag, err = p.expectTag(); err != nil {
+		return nil, err
+	}
+	if _, _, err = p.expect(tokLBrace); err != nil {
+		return nil, err
+	}
+	for p.tok != tokRBrace {
+		kpos, kind, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			typeName string
+			decl     *TypeDecl
+			isNil    bool
+		)
+		switch p.tok {
+		case tokIdent:
+			typeName = p.lit
+			if kind == "response" && typeName == "nil" {
+				isNil = true
+				typeName = ""
+			}
+			if err = p.next(); err != nil {
+				return nil, err
+			}
+		case tokLBrace:
+			decl = &TypeDecl{Pos: p.pos, Name: kind}
+			if err = p.parseTypeBody(decl); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected("type name or '{'")
+		}
+
+		switch kind {
+		case "request":
+			if proto.RequestName != "" || proto.Request != nil {
+				return nil, errorf(kpos, "protocol %s has repeated request", name)
+			}
+			proto.RequestName, proto.Request = typeName, decl
+		case "response":
+			if proto.ResponseName != "" || proto.Response != nil || proto.ResponseNil {
+				return nil, errorf(kpos, "protocol %s has repeated response", name)
+			}
+			proto.ResponseName, proto.Response, proto.ResponseNil = typeName, decl, isNil
+		default:
+			return nil, errorf(kpos, "expected request or response, found %q", kind)
+		}
+	}
+	return proto, p.next()
+}
+
+// Parse parses the source of one schema file; filename is only used in positions.
+func Parse(filename string, src []byte) (*File, error) {
+	p := &parser{s: newScanner(filename, src)}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return p.parseFile(filename)
+}
+
+// ParseFile reads and parses a schema file.
+func ParseFile(filename string) (*File, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filename, src)
+}
+
+// ParseFiles parses schema files and resolves them as a whole, types declared
+// in one file can be referenced from the others.
+func ParseFiles(filenames ...string) (*Schema, error) {
+	files := make([]*File, 0, len(filenames))
+	for _, filename := range filenames {
+		f, err := ParseFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return Resolve(files...)
+}
@@ -0,0 +1,110 @@
+package schema
+
+import (
+	"testing"
+)
+
+const testSchema = `
+.package {
+	type 0 : integer
+	session 1 : integer
+}
+
+.Person {	# . means a user defined type
+	name 0 : string
+	id 1 : integer
+
+	.PhoneNumber {
+		number 0 : string
+		type 1 : integer
+	}
+
+	phone 3 : *PhoneNumber
+	height 4 : integer(2)
+	pics 5 : *binary
+}
+
+.Bank {
+	owner 0 : Person.PhoneNumber
+	clients 1 : *Person(id)
+	phones 2 : *Person.PhoneNumber()
+}
+
+foobar 1 {
+	request Person
+	response {
+		ok 0 : boolean
+	}
+}
+
+bar 2 {
+	response nil
+}
+`
+
+func TestParse(t *testing.T) {
+	f, err := Parse("test.sproto", []byte(testSchema))
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	if len(f.Types) != 3 || len(f.Protocols) != 2 {
+		t.Fatalf("unexpected declarations: %d types, %d protocols", len(f.Types), len(f.Protocols))
+	}
+
+	person := f.Types[1]
+	if person.Name != "Person" || len(person.Fields) != 5 || len(person.Types) != 1 {
+		t.Fatalf("unexpected type: %+v", person)
+	}
+	if person.Pos.Line != 7 || person.Pos.Column != 1 {
+		t.Fatalf("unexpected position: %s", person.Pos)
+	}
+	phone := person.Fields[2]
+	if phone.Name != "phone" || phone.Tag != 3 || !phone.Array || phone.TypeName != "PhoneNumber" {
+		t.Fatalf("unexpected field: %+v", phone)
+	}
+	if height := person.Fields[3]; height.Decimal != 2 {
+		t.Fatalf("unexpected field: %+v", height)
+	}
+
+	bank := f.Types[2]
+	if clients := bank.Fields[1]; !clients.HasKey || clients.Key != "id" {
+		t.Fatalf("unexpected field: %+v", clients)
+	}
+	if phones := bank.Fields[2]; !phones.HasKey || phones.Key != "" || phones.TypeName != "Person.PhoneNumber" {
+		t.Fatalf("unexpected field: %+v", phones)
+	}
+
+	foobar := f.Protocols[0]
+	if foobar.Name != "foobar" || foobar.Tag != 1 || foobar.RequestName != "Person" || foobar.Response == nil {
+		t.Fatalf("unexpected protocol: %+v", foobar)
+	}
+	if bar := f.Protocols[1]; !bar.ResponseNil || bar.Request != nil || bar.RequestName != "" {
+		t.Fatalf("unexpected protocol: %+v", bar)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		src string
+		err string
+	}{
+		{".Foo {\n  a 0 string\n}", "test.sproto:2:7: expected ':', found identifier \"string\""},
+		{".Foo {\n  a 99999 : string\n}", "test.sproto:2:5: tag 99999 out of range [0, 32766]"},
+		{".Foo {\n  a 0 : string(1)\n}", "test.sproto:2:15: main index is only allowed on array field a"},
+		{".Foo {\n  a 0 : string\n", "test.sproto:3:1: expected field or type declaration, found end of file"},
+		{"foo 1 {\n  query Foo\n}", "test.sproto:2:3: expected request or response, found \"query\""},
+		{"foo 1 {\n  request Foo\n  request Bar\n}", "test.sproto:3:3: protocol foo has repeated request"},
+		{".Foo {\n  a 0 : integer(x)\n}", "test.sproto:2:17: expected number, found identifier \"x\""},
+		{".Foo {\n  a 0 : integer(19)\n}", "test.sproto:2:17: decimal precision 19 out of range [1, 18]"},
+		{".Foo { a 0 : string } $", "test.sproto:1:23: unexpected character '$'"},
+	}
+	for _, c := range cases {
+		_, err := Parse("test.sproto", []byte(c.src))
+		if err == nil {
+			t.Fatalf("parse %q: expected error", c.src)
+		}
+		if err.Error() != c.err {
+			t.Fatalf("parse %q: unexpected error: %s", c.src, err)
+		}
+	}
+}
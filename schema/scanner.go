@@ -0,0 +1,149 @@
+package schema
+
+import (
+	"strconv"
+)
+
+type token int
+
+const (
+	tokEOF token = iota
+	tokIdent
+	tokNumber
+	tokDot    // .
+	tokLBrace // {
+	tokRBrace // }
+	tokColon  // :
+	tokStar   // *
+	tokLParen // (
+	tokRParen // )
+)
+
+var tokenNames = [...]string{
+	tokEOF:    "end of file",
+	tokIdent:  "identifier",
+	tokNumber: "number",
+	tokDot:    "'.'",
+	tokLBrace: "'{'",
+	tokRBrace: "'}'",
+	tokColon:  "':'",
+	tokStar:   "'*'",
+	tokLParen: "'('",
+	tokRParen: "')'",
+}
+
+func (t token) String() string {
+	return tokenNames[t]
+}
+
+type scanner struct {
+	filename string
+	src      []byte
+	offset   int
+	line     int
+	column   int
+}
+
+func newScanner(filename string, src []byte) *scanner {
+	return &scanner{
+		filename: filename,
+		src:      src,
+		line:     1,
+		column:   1,
+	}
+}
+
+func (s *scanner) pos() Pos {
+	return Pos{Filename: s.filename, Line: s.line, Column: s.column}
+}
+
+func (s *scanner) peekByte() byte {
+	if s.offset < len(s.src) {
+		return s.src[s.offset]
+	}
+	return 0
+}
+
+func (s *scanner) nextByte() byte {
+	c := s.src[s.offset]
+	s.offset++
+	if c == '\n' {
+		s.line++
+		s.column = 1
+	} else {
+		s.column++
+	}
+	return c
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// skip spaces and comments, a comment starts with '#' and ends at line end
+func (s *scanner) skipSpace() {
+	for s.offset < len(s.src) {
+		switch c := s.peekByte(); {
+		case c == ' ', c == '\t', c == '\r', c == '\n':
+			s.nextByte()
+		case c == '#':
+			for s.offset < len(s.src) && s.peekByte() != '\n' {
+				s.nextByte()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// scan returns the next token, its position and literal text
+func (s *scanner) scan() (tok token, pos Pos, lit string, err error) {
+	s.skipSpace()
+	pos = s.pos()
+	if s.offset >= len(s.src) {
+		tok = tokEOF
+		return
+	}
+
+	start := s.offset
+	c := s.nextByte()
+	switch {
+	case isLetter(c):
+		for s.offset < len(s.src) {
+			c = s.peekByte()
+			if !isLetter(c) && !isDigit(c) && c != '.' {
+				break
+			}
+			s.nextByte()
+		}
+		tok = tokIdent
+	case isDigit(c):
+		for s.offset < len(s.src) && isDigit(s.peekByte()) {
+			s.nextByte()
+		}
+		tok = tokNumber
+	case c == '.':
+		tok = tokDot
+	case c == '{':
+		tok = tokLBrace
+	case c == '}':
+		tok = tokRBrace
+	case c == ':':
+		tok = tokColon
+	case c == '*':
+		tok = tokStar
+	case c == '(':
+		tok = tokLParen
+	case c == ')':
+		tok = tokRParen
+	default:
+		err = errorf(pos, "unexpected character %s", strconv.QuoteRune(rune(c)))
+		return
+	}
+	lit = string(s.src[start:s.offset])
+	return
+}
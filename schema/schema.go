@@ -0,0 +1,297 @@
+package schema
+
+import "sort"
+
+// builtin types
+const (
+	Integer = "integer"
+	Boolean = "boolean"
+	String  = "string"
+	Binary  = "binary"
+	Double  = "double"
+)
+
+func isBuiltin(name string) bool {
+	switch name {
+	case Integer, Boolean, String, Binary, Double:
+		return true
+	}
+	return false
+}
+
+// Schema is the resolved type graph of one or more schema files.
+type Schema struct {
+	Types     []*Type // user types in declaration order, nested types follow their parent
+	Protocols []*Protocol
+
+	types     map[string]*Type
+	protocols map[string]*Protocol
+}
+
+// Type looks up a user type by its full name, e.g. "Person.PhoneNumber".
+func (s *Schema) Type(name string) *Type {
+	return s.types[name]
+}
+
+// Protocol looks up a protocol by name.
+func (s *Schema) Protocol(name string) *Protocol {
+	return s.protocols[name]
+}
+
+// Type is a resolved user type.
+type Type struct {
+	Name   string // full name, nested names are joined with '.'
+	Pos    Pos
+	Fields []*Field // ordered by tag
+	Parent *Type    // enclosing type of a nested type
+	Types  []*Type  // nested types
+
+	decl *TypeDecl
+}
+
+// FieldByTag returns the field with tag, or nil.
+func (t *Type) FieldByTag(tag int) *Field {
+	for _, f := range t.Fields {
+		if f.Tag == tag {
+			return f
+		}
+	}
+	return nil
+}
+
+// FieldByName returns the field with name, or nil.
+func (t *Type) FieldByName(name string) *Field {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Field is a resolved field.
+type Field struct {
+	Name     string
+	Pos      Pos
+	Tag      int
+	Array    bool
+	TypeName string // builtin name or full name of Type
+	Type     *Type  // user type, nil for builtin types
+	Decimal  int    // n of integer(n), 0 if absent
+
+	// maps are arrays of a user type with a main index:
+	// *Type(key) sets Key and keeps the whole element as value,
+	// *Type() uses the two fields of Type as Key and Value.
+	Key   *Field
+	Value *Field
+}
+
+// IsBuiltin reports whether the field has a builtin type.
+func (f *Field) IsBuiltin() bool {
+	return f.Type == nil
+}
+
+// IsMap reports whether the field is an array with a main index.
+func (f *Field) IsMap() bool {
+	return f.Key != nil
+}
+
+// Protocol is a resolved protocol.
+type Protocol struct {
+	Name        string
+	Pos         Pos
+	Tag         int
+	Request     *Type
+	Response    *Type
+	ResponseNil bool // the response carries no content
+}
+
+type resolver struct {
+	schema *Schema
+	all    []*Type // every declared type, including inline protocol types
+}
+
+// declare registers decl and its nested types under full names
+func (r *resolver) declare(parent *Type, decl *TypeDecl, name string, public bool) (*Type, error) {
+	if old, ok := r.schema.types[name]; ok {
+		return nil, errorf(decl.Pos, "type %s redefined, previous declaration at %s", name, old.Pos)
+	}
+	t := &Type{
+		Name:   name,
+		Pos:    decl.Pos,
+		Parent: parent,
+		decl:   decl,
+	}
+	r.schema.types[name] = t
+	r.all = append(r.all, t)
+	if public {
+		r.schema.Types = append(r.schema.Types, t)
+	}
+	for _, nested := range decl.Types {
+		nt, err := r.declare(t, nested, name+"."+nested.Name, public)
+		if err != nil {
+			return nil, err
+		}
+		t.Types = append(t.Types, nt)
+	}
+	return t, nil
+}
+
+// lookup searches name from the innermost scope outwards
+func (r *resolver) lookup(scope *Type, name string) *Type {
+	for s := scope; s != nil; s = s.Parent {
+		if t, ok := r.schema.types[s.Name+"."+name]; ok {
+			return t
+		}
+	}
+	return r.schema.types[name]
+}
+
+func (r *resolver) resolveFields(t *Type) error {
+	tags := make(map[int]*Field)
+	names := make(map[string]*Field)
+	for _, decl := range t.decl.Fields {
+		if old, ok := tags[decl.Tag]; ok {
+			return errorf(decl.Pos, "%s.%s: tag %d already used by field %s", t.Name, decl.Name, decl.Tag, old.Name)
+		}
+		if _, ok := names[decl.Name]; ok {
+			return errorf(decl.Pos, "%s.%s: field redefined", t.Name, decl.Name)
+		}
+
+		f := &Field{
+			Name:     decl.Name,
+			Pos:      decl.Pos,
+			Tag:      decl.Tag,
+			Array:    decl.Array,
+			TypeName: decl.TypeName,
+			Decimal:  decl.Decimal,
+		}
+		if !isBuiltin(decl.TypeName) {
+			if f.Type = r.lookup(t, decl.TypeName); f.Type == nil {
+				return errorf(decl.Pos, "%s.%s: undefined type %s", t.Name, decl.Name, decl.TypeName)
+			}
+			f.TypeName = f.Type.Name
+		}
+		tags[f.Tag] = f
+		names[f.Name] = f
+		t.Fields = append(t.Fields, f)
+	}
+	sort.SliceStable(t.Fields, func(i, j int) bool {
+		return t.Fields[i].Tag < t.Fields[j].Tag
+	})
+	return nil
+}
+
+func checkKey(t *Type, decl *FieldDecl, key *Field) error {
+	if key.Array || key.Decimal > 0 || (key.TypeName != Integer && key.TypeName != String) {
+		return errorf(decl.Pos, "%s.%s: main index %s must be integer or string", t.Name, decl.Name, key.Name)
+	}
+	return nil
+}
+
+// resolveKeys must run after the fields of all types have been resolved
+func (r *resolver) resolveKeys(t *Type) error {
+	for _, decl := range t.decl.Fields {
+		if !decl.HasKey {
+			continue
+		}
+		f := t.FieldByName(decl.Name)
+		if f.Type == nil {
+			return errorf(decl.Pos, "%s.%s: main index requires a user type, not %s", t.Name, decl.Name, f.TypeName)
+		}
+		elem := f.Type
+		if decl.Key != "" {
+			if f.Key = elem.FieldByName(decl.Key); f.Key == nil {
+				return errorf(decl.Pos, "%s.%s: type %s has no field %s", t.Name, decl.Name, elem.Name, decl.Key)
+			}
+		} else {
+			if len(elem.Fields) != 2 {
+				return errorf(decl.Pos, "%s.%s: type %s must have exactly 2 fields to be used as map", t.Name, decl.Name, elem.Name)
+			}
+			f.Key, f.Value = elem.Fields[0], elem.Fields[1]
+		}
+		if err := checkKey(t, decl, f.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) protocolType(proto *ProtocolDecl, name string, decl *TypeDecl) (*Type, error) {
+	if decl != nil {
+		return r.declare(nil, decl, proto.Name+"."+decl.Name, false)
+	}
+	if name == "" {
+		return nil, nil
+	}
+	t := r.lookup(nil, name)
+	if t == nil {
+		return nil, errorf(proto.Pos, "protocol %s: undefined type %s", proto.Name, name)
+	}
+	return t, nil
+}
+
+func (r *resolver) resolveProtocols(files []*File) error {
+	tags := make(map[int]*Protocol)
+	for _, file := range files {
+		for _, decl := range file.Protocols {
+			if old, ok := r.schema.protocols[decl.Name]; ok {
+				return errorf(decl.Pos, "protocol %s redefined, previous declaration at %s", decl.Name, old.Pos)
+			}
+			if old, ok := tags[decl.Tag]; ok {
+				return errorf(decl.Pos, "protocol %s: tag %d already used by protocol %s", decl.Name, decl.Tag, old.Name)
+			}
+			proto := &Protocol{
+				Name:        decl.Name,
+				Pos:         decl.Pos,
+				Tag:         decl.Tag,
+				ResponseNil: decl.ResponseNil,
+			}
+			var err error
+			if proto.Request, err = r.protocolType(decl, decl.RequestName, decl.Request); err != nil {
+				return err
+			}
+			if proto.Response, err = r.protocolType(decl, decl.ResponseName, decl.Response); err != nil {
+				return err
+			}
+			r.schema.protocols[proto.Name] = proto
+			r.schema.Protocols = append(r.schema.Protocols, proto)
+			tags[proto.Tag] = proto
+		}
+	}
+	return nil
+}
+
+// Resolve links the type references of files into a Schema. Type names are
+// shared by all files; a reference inside a nested type is searched from the
+// innermost enclosing type outwards.
+func Resolve(files ...*File) (*Schema, error) {
+	r := &resolver{
+		schema: &Schema{
+			types:     make(map[string]*Type),
+			protocols: make(map[string]*Protocol),
+		},
+	}
+	for _, file := range files {
+		for _, decl := range file.Types {
+			if _, err := r.declare(nil, decl, decl.Name, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// inline protocol types are declared here, so they join r.all
+	if err := r.resolveProtocols(files); err != nil {
+		return nil, err
+	}
+	for _, t := range r.all {
+		if err := r.resolveFields(t); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range r.all {
+		if err := r.resolveKeys(t); err != nil {
+			return nil, err
+		}
+	}
+	return r.schema, nil
+}
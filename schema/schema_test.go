@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"testing"
+)
+
+func mustResolve(t *testing.T, srcs ...string) *Schema {
+	files := make([]*File, len(srcs))
+	for i, src := range srcs {
+		f, err := Parse("test.sproto", []byte(src))
+		if err != nil {
+			t.Fatalf("parse failed: %s", err)
+		}
+		files[i] = f
+	}
+	s, err := Resolve(files...)
+	if err != nil {
+		t.Fatalf("resolve failed: %s", err)
+	}
+	return s
+}
+
+func TestResolve(t *testing.T) {
+	s := mustResolve(t, testSchema)
+
+	var names []string
+	for _, typ := range s.Types {
+		names = append(names, typ.Name)
+	}
+	if len(names) != 4 || names[0] != "package" || names[1] != "Person" || names[2] != "Person.PhoneNumber" || names[3] != "Bank" {
+		t.Fatalf("unexpected types: %v", names)
+	}
+
+	person := s.Type("Person")
+	phoneNumber := s.Type("Person.PhoneNumber")
+	if phoneNumber == nil || phoneNumber.Parent != person || len(person.Types) != 1 {
+		t.Fatal("unexpected nested type")
+	}
+	if phone := person.FieldByName("phone"); phone.Type != phoneNumber || phone.TypeName != "Person.PhoneNumber" || phone.IsBuiltin() {
+		t.Fatalf("unexpected field: %+v", phone)
+	}
+	if pics := person.FieldByTag(5); pics.Name != "pics" || !pics.IsBuiltin() || !pics.Array || pics.TypeName != Binary {
+		t.Fatalf("unexpected field: %+v", pics)
+	}
+
+	bank := s.Type("Bank")
+	if owner := bank.FieldByName("owner"); owner.Type != phoneNumber {
+		t.Fatalf("unexpected field: %+v", owner)
+	}
+	clients := bank.FieldByName("clients")
+	if !clients.IsMap() || clients.Key != person.FieldByName("id") || clients.Value != nil {
+		t.Fatalf("unexpected field: %+v", clients)
+	}
+	phones := bank.FieldByName("phones")
+	if !phones.IsMap() || phones.Key.Name != "number" || phones.Value.Name != "type" {
+		t.Fatalf("unexpected field: %+v", phones)
+	}
+
+	foobar := s.Protocol("foobar")
+	if foobar.Request != person || foobar.Response == nil || foobar.Response.Name != "foobar.response" {
+		t.Fatalf("unexpected protocol: %+v", foobar)
+	}
+	if ok := foobar.Response.FieldByTag(0); ok == nil || ok.TypeName != Boolean {
+		t.Fatalf("unexpected response: %+v", foobar.Response)
+	}
+	if bar := s.Protocol("bar"); bar.Request != nil || bar.Response != nil || !bar.ResponseNil {
+		t.Fatalf("unexpected protocol: %+v", bar)
+	}
+}
+
+func TestResolveMultipleFiles(t *testing.T) {
+	s := mustResolve(t, ".Foo {\n bar 0 : Bar\n}", ".Bar {\n foo 0 : *Foo\n}\nping 1 {\n request Foo\n}")
+	if s.Type("Foo").Fields[0].Type != s.Type("Bar") || s.Protocol("ping").Request != s.Type("Foo") {
+		t.Fatal("unexpected cross file reference")
+	}
+}
+
+func TestResolveScope(t *testing.T) {
+	s := mustResolve(t, `
+.Item {
+	id 0 : integer
+}
+.Outer {
+	.Item {
+		name 0 : string
+	}
+	.Inner {
+		item 0 : Item
+	}
+	item 0 : Item
+}
+`)
+	if f := s.Type("Outer.Inner").Fields[0]; f.Type != s.Type("Outer.Item") {
+		t.Fatalf("unexpected scope: %s", f.TypeName)
+	}
+	if f := s.Type("Outer").Fields[0]; f.Type != s.Type("Outer.Item") {
+		t.Fatalf("unexpected scope: %s", f.TypeName)
+	}
+}
+
+func TestResolveError(t *testing.T) {
+	cases := []struct {
+		src string
+		err string
+	}{
+		{".Foo {\n  a 0 : Bar\n}", "test.sproto:2:3: Foo.a: undefined type Bar"},
+		{".Foo {\n  a 0 : string\n  b 0 : string\n}", "test.sproto:3:3: Foo.b: tag 0 already used by field a"},
+		{".Foo {\n  a 0 : string\n  a 1 : string\n}", "test.sproto:3:3: Foo.a: field redefined"},
+		{".Foo {\n}\n.Foo {\n}", "test.sproto:3:1: type Foo redefined, previous declaration at test.sproto:1:1"},
+		{".Foo {\n  a 0 : *Foo(b)\n}", "test.sproto:2:3: Foo.a: type Foo has no field b"},
+		{".Foo {\n  a 0 : *Foo(a)\n}", "test.sproto:2:3: Foo.a: main index a must be integer or string"},
+		{".Foo {\n  a 0 : *Foo()\n}", "test.sproto:2:3: Foo.a: type Foo must have exactly 2 fields to be used as map"},
+		{".Foo {\n  a 0 : *string()\n}", "test.sproto:2:3: Foo.a: main index requires a user type, not string"},
+		{"foo 1 {\n  request Bar\n}", "test.sproto:1:1: protocol foo: undefined type Bar"},
+		{"foo 1 {\n}\nfoo 2 {\n}", "test.sproto:3:1: protocol foo redefined, previous declaration at test.sproto:1:1"},
+		{"foo 1 {\n}\nbar 1 {\n}", "test.sproto:3:1: protocol bar: tag 1 already used by protocol foo"},
+	}
+	for _, c := range cases {
+		f, err := Parse("test.sproto", []byte(c.src))
+		if err != nil {
+			t.Fatalf("parse %q failed: %s", c.src, err)
+		}
+		_, err = Resolve(f)
+		if err == nil {
+			t.Fatalf("resolve %q: expected error", c.src)
+		}
+		if err.Error() != c.err {
+			t.Fatalf("resolve %q: unexpected error: %s", c.src, err)
+		}
+	}
+}
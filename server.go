@@ -0,0 +1,288 @@
+package sproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// max delay between retries of temporary Accept errors
+const maxAcceptDelay = time.Second
+
+// OnConnect is called with the service of a new connection before it
+// dispatches, it may configure the service. Returning an error closes the
+// connection.
+type OnConnect func(s *Service) error
+
+// OnDisconnect is called after the service of a connection stops, err is the
+// error that stopped its dispatching.
+type OnDisconnect func(s *Service, err error)
+
+// Server serves connections accepted from listeners, each connection has its
+// own Service sharing the receivers registered on the server.
+type Server struct {
+	protocols    []*Protocol
+	methods      map[string]*Method
+	onConnect    OnConnect
+	onDisconnect OnDisconnect
+	maxConns     int
+	skipUnknown  bool
+
+	mutex     sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	services  map[*Service]struct{}
+	conns     sync.WaitGroup
+}
+
+func NewServer(protocols []*Protocol) (*Server, error) {
+	if _, err := NewRpc(protocols); err != nil {
+		return nil, err
+	}
+	return &Server{
+		protocols: protocols,
+		methods:   make(map[string]*Method),
+		listeners: make(map[net.Listener]struct{}),
+		services:  make(map[*Service]struct{}),
+	}, nil
+}
+
+// Register registers the methods of receiver for all connections, it should
+// be called before serving.
+func (srv *Server) Register(receiver interface{}) error {
+	// validate with a template service
+	s, err := NewService(nil, srv.protocols)
+	if err != nil {
+		return err
+	}
+	s.SetSkipUnknownMethods(srv.skipUnknown)
+	if err := s.Register(receiver); err != nil {
+		return err
+	}
+	return srv.addMethods(s)
+}
+
+// Handle registers fn as the handler of protocol name for all connections,
+// see Service.Handle. It should be called before serving.
+func (srv *Server) Handle(name string, fn interface{}) error {
+	s, err := NewService(nil, srv.protocols)
+	if err != nil {
+		return err
+	}
+	if err := s.Handle(name, fn); err != nil {
+		return err
+	}
+	return srv.addMethods(s)
+}
+
+// SetSkipUnknownMethods makes Register skip methods matching no protocol,
+// see Service.SetSkipUnknownMethods.
+func (srv *Server) SetSkipUnknownMethods(skip bool) {
+	srv.skipUnknown = skip
+}
+
+// addMethods adds methods of the template service s
+func (srv *Server) addMethods(s *Service) error {
+	for name := range s.methods {
+		if _, ok := srv.methods[name]; ok {
+			return fmt.Errorf("sproto:service %s has already registered", name)
+		}
+	}
+	for name, method := range s.methods {
+		srv.methods[name] = method
+	}
+	return nil
+}
+
+func (srv *Server) SetOnConnect(onConnect OnConnect) {
+	srv.onConnect = onConnect
+}
+
+func (srv *Server) SetOnDisconnect(onDisconnect OnDisconnect) {
+	srv.onDisconnect = onDisconnect
+}
+
+// SetMaxConns limits concurrent connections, connections over the limit are
+// closed once accepted. 0 means unlimited.
+func (srv *Server) SetMaxConns(n int) {
+	srv.maxConns = n
+}
+
+// Serve accepts connections from ln until it fails or the server is shut
+// down, then it returns ErrServerClosed. Temporary Accept errors are retried
+// with backoff.
+func (srv *Server) Serve(ln net.Listener) error {
+	srv.mutex.Lock()
+	if srv.closing {
+		srv.mutex.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	srv.listeners[ln] = struct{}{}
+	srv.mutex.Unlock()
+
+	defer func() {
+		srv.mutex.Lock()
+		delete(srv.listeners, ln)
+		srv.mutex.Unlock()
+		ln.Close()
+	}()
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if srv.isClosing() {
+				return ErrServerClosed
+			}
+			if isTemporaryAccept(err) {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				if delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go srv.ServeConn(conn)
+	}
+}
+
+// isTemporaryAccept reports whether Accept may succeed later after err, e.g.
+// a connection aborted before accepted or running out of file descriptors
+func isTemporaryAccept(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeConn serves conn until it's closed, e.g. one end of net.Pipe.
+func (srv *Server) ServeConn(conn net.Conn) {
+	s, err := srv.newService(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	srv.serveConn(s)
+}
+
+// ListenAndServe listens on the TCP address addr and serves it.
+func (srv *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(ln)
+}
+
+func (srv *Server) isClosing() bool {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return srv.closing
+}
+
+// newService tracks the service of conn, it fails if the server is closing or
+// full
+func (srv *Server) newService(conn net.Conn) (*Service, error) {
+	s, err := NewService(conn, srv.protocols)
+	if err != nil {
+		return nil, err
+	}
+	for name, method := range srv.methods {
+		s.methods[name] = method
+	}
+
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.closing {
+		return nil, ErrServerClosed
+	}
+	if srv.maxConns > 0 && len(srv.services) >= srv.maxConns {
+		return nil, fmt.Errorf("sproto: server reaches max connections %d", srv.maxConns)
+	}
+	srv.services[s] = struct{}{}
+	srv.conns.Add(1)
+	return s, nil
+}
+
+func (srv *Server) serveConn(s *Service) {
+	defer func() {
+		srv.mutex.Lock()
+		delete(srv.services, s)
+		srv.mutex.Unlock()
+		srv.conns.Done()
+	}()
+
+	if srv.onConnect != nil {
+		if err := srv.onConnect(s); err != nil {
+			s.Close()
+			return
+		}
+	}
+	err := s.Dispatch()
+	if srv.onDisconnect != nil {
+		srv.onDisconnect(s, err)
+	}
+}
+
+// stop closes listeners and refuses new connections, it returns services of
+// current connections
+func (srv *Server) stop() []*Service {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.closing = true
+	for ln := range srv.listeners {
+		ln.Close()
+	}
+	services := make([]*Service, 0, len(srv.services))
+	for s := range srv.services {
+		services = append(services, s)
+	}
+	return services
+}
+
+// Close stops the server and closes all connections immediately.
+func (srv *Server) Close() error {
+	for _, s := range srv.stop() {
+		s.Close()
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, then shuts down the service of every
+// connection, which waits for its in-flight requests and calls. If ctx is done
+// first, remaining connections are closed and ctx.Err() is returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	for _, s := range srv.stop() {
+		go s.Shutdown(ctx)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Close()
+		return ctx.Err()
+	}
+}
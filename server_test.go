@@ -0,0 +1,181 @@
+package sproto
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, srv *Server) (addr string, served chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%s", err)
+	}
+	served = make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	return ln.Addr().String(), served
+}
+
+func dialService(t *testing.T, addr string, protocols []*Protocol) *Service {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed:%s", err)
+	}
+	client, _ := NewService(conn, protocols)
+	go client.Dispatch()
+	return client
+}
+
+func TestServer(t *testing.T) {
+	srv, _ := NewServer(protocols)
+	if err := srv.Register(&inst); err != nil {
+		t.Fatalf("register service failed:%s", err)
+	}
+	if err := srv.Register(&inst); err == nil {
+		t.Fatal("repeated register should fail")
+	}
+	connected := make(chan *Service, 2)
+	disconnected := make(chan error, 2)
+	srv.SetOnConnect(func(s *Service) error {
+		connected <- s
+		return nil
+	})
+	srv.SetOnDisconnect(func(s *Service, err error) {
+		disconnected <- err
+	})
+	srv.SetMaxConns(1)
+	addr, served := startServer(t, srv)
+
+	client := dialService(t, addr, protocols)
+	resp, err := client.Call("test.foobar", &FoobarRequest{What: String("hello")})
+	if err != nil || *resp.(*FoobarResponse).What != "hello" {
+		t.Fatalf("unexpected response:%v, err:%v", resp, err)
+	}
+	<-connected
+
+	// over max connections
+	other := dialService(t, addr, protocols)
+	select {
+	case <-other.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection over limit isn't closed")
+	}
+
+	client.Close()
+	<-disconnected
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed:%s", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	slow := &Slow{release: make(chan struct{}), entered: make(chan struct{}, 1)}
+	srv, _ := NewServer(slowProtocols)
+	srv.Register(slow)
+	addr, served := startServer(t, srv)
+
+	client := dialService(t, addr, slowProtocols)
+	call, _ := client.Go("slow.wait", &FoobarRequest{What: String("slow")}, nil)
+	<-slow.entered
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- srv.Shutdown(context.Background())
+	}()
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown doesn't wait for in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(slow.release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown failed:%s", err)
+	}
+	if call = <-call.Done; call.Err != nil || *call.Resp.(*FoobarResponse).What != "slow" {
+		t.Fatalf("unexpected response:%v, err:%v", call.Resp, call.Err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	slow := &Slow{release: make(chan struct{}), entered: make(chan struct{}, 1)}
+	defer close(slow.release)
+	srv, _ := NewServer(slowProtocols)
+	srv.Register(slow)
+	addr, _ := startServer(t, srv)
+
+	client := dialService(t, addr, slowProtocols)
+	call, _ := client.Go("slow.wait", &FoobarRequest{What: String("slow")}, nil)
+	<-slow.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	select {
+	case call = <-call.Done:
+	case <-time.After(time.Second):
+		t.Fatal("connection isn't closed when shutdown times out")
+	}
+	if call.Err != ErrServiceClosed {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+}
+
+// flakyListener fails Accept with errs before accepting from Listener
+type flakyListener struct {
+	net.Listener
+	errs []error
+}
+
+func (ln *flakyListener) Accept() (net.Conn, error) {
+	if len(ln.errs) > 0 {
+		err := ln.errs[0]
+		ln.errs = ln.errs[1:]
+		return nil, err
+	}
+	return ln.Listener.Accept()
+}
+
+func TestServerAcceptError(t *testing.T) {
+	srv, _ := NewServer(protocols)
+	srv.Register(&inst)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%s", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(&flakyListener{Listener: ln, errs: []error{
+			&net.OpError{Op: "accept", Err: syscall.ECONNABORTED},
+			&net.OpError{Op: "accept", Err: syscall.EMFILE},
+		}})
+	}()
+	client := dialService(t, ln.Addr().String(), protocols)
+	defer client.Close()
+	if _, err := client.Call("test.foobar", &FoobarRequest{What: String("hello")}); err != nil {
+		t.Fatalf("call failed after temporary errors:%s", err)
+	}
+	srv.Close()
+	<-served
+
+	// other errors stop serving
+	errAccept := errors.New("accept failed")
+	srv, _ = NewServer(protocols)
+	ln, _ = net.Listen("tcp", "127.0.0.1:0")
+	if err := srv.Serve(&flakyListener{Listener: ln, errs: []error{errAccept}}); err != errAccept {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
@@ -1,61 +1,141 @@
 package sproto
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"reflect"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
 	MSG_MAX_LEN = 0xffff
 )
 
+// interval to check pending calls during Shutdown
+const shutdownPollInterval = 10 * time.Millisecond
+
+// queued requests of ordered protocols before dispatching blocks
+const orderedQueueSize = 64
+
 type OnUnknownPacket func(mode RpcMode, name string, session int32, sp interface{}) error
 
 func defaultOnUnknownPacket(mode RpcMode, name string, session int32, sp interface{}) error {
 	return fmt.Errorf("sproto: unknown packet, mode:%d, name:%s, session:%d", mode, name, session)
 }
 
+// IgnoreUnknownResponses is an OnUnknownPacket dropping responses of unknown
+// sessions, e.g. late responses of canceled calls, other packets fail as by
+// default.
+func IgnoreUnknownResponses(mode RpcMode, name string, session int32, sp interface{}) error {
+	if mode == RpcResponseMode {
+		return nil
+	}
+	return defaultOnUnknownPacket(mode, name, session, sp)
+}
+
+// OnHandlerError handles the error returned by the handler of request ctx.
+// Returning nil replies the error to the caller as *RemoteError, returning an
+// error stops dispatching with it.
+type OnHandlerError func(ctx context.Context, err error) error
+
+func defaultOnHandlerError(ctx context.Context, err error) error {
+	return nil
+}
+
+// OnHandlerPanic is called with the value and stack trace of a panic
+// recovered from the handler of protocol p, then the handler fails with
+// ErrHandlerPanic. The peer is only replied CodeInternal, details of the
+// panic stay local.
+type OnHandlerPanic func(ctx context.Context, p *Protocol, v interface{}, stack []byte)
+
+func defaultOnHandlerPanic(ctx context.Context, p *Protocol, v interface{}, stack []byte) {
+	log.Printf("sproto: handler of %s panic: %v\n%s", p.Name, v, stack)
+}
+
 type Method struct {
-	rcvr     reflect.Value
-	method   reflect.Method
-	protocol *Protocol
+	rcvr        reflect.Value // invalid for functions registered by Handle or OnNotify
+	fn          reflect.Value
+	protocol    *Protocol
+	withContext bool
+	withError   bool
+	listeners   []*Method // subscribers of a notification, see OnNotify
 }
 
-func (m *Method) call(req interface{}) interface{} {
+func (m *Method) call(ctx context.Context, req interface{}) (interface{}, error) {
+	if m.listeners != nil {
+		var err error
+		for _, l := range m.listeners {
+			if _, lerr := l.call(ctx, req); lerr != nil && err == nil {
+				err = lerr
+			}
+		}
+		return nil, err
+	}
 	var resp reflect.Value
-	in := make([]reflect.Value, m.method.Type.NumIn())
-	in[0] = m.rcvr
+	in := make([]reflect.Value, m.fn.Type().NumIn())
+	arg := 0
+	if m.rcvr.IsValid() {
+		in[0] = m.rcvr
+		arg++
+	}
+	if m.withContext {
+		in[arg] = reflect.ValueOf(&ctx).Elem()
+		arg++
+	}
 	if m.protocol.HasRequest() {
-		in[1] = reflect.ValueOf(req)
+		in[arg] = reflect.ValueOf(req)
 	}
 	if m.protocol.HasResponse() {
 		resp = reflect.New(m.protocol.Response.Elem())
 		in[len(in)-1] = resp
 	}
-	m.method.Func.Call(in)
+	out := m.fn.Call(in)
+	var err error
+	if m.withError && !out[0].IsNil() {
+		err = out[0].Interface().(error)
+	}
 	if resp.IsValid() {
-		return resp.Interface()
+		return resp.Interface(), err
 	}
-	return nil
+	return nil, err
 }
 
+// request waiting for a handler in concurrent mode
+type request struct {
+	method  *Method
+	name    string
+	session int32
+	sp      interface{}
+	header  interface{}
+}
+
+// Call is an asynchronous call started by Go. Unlike net/rpc, which blocks
+// until Done has room, a call completing when its Done channel is full is
+// discarded with a log, so Done must be sized for all calls sharing it.
 type Call struct {
 	protocol *Protocol
+	session  int32
+	finished chan struct{} // closed when done, only for calls with context
 	Resp     interface{}
+	Header   interface{} // package header of the response, see SetHeaderType
 	Err      error
 	Done     chan *Call
 }
 
 func (call *Call) done() {
+	if call.finished != nil {
+		close(call.finished)
+	}
 	select {
 	case call.Done <- call:
 	default:
-		log.Panicf("sproto: method %s block", call.protocol.MethodName)
+		log.Printf("sproto: done channel of %s is full, discard the call", call.protocol.Name)
 	}
 }
 
@@ -64,24 +144,57 @@ type Service struct {
 	readMutex    sync.Mutex // gates read one at a time
 	writeMutex   sync.Mutex // gates write one at a time
 	rw           io.ReadWriter
-	rdbuf        []byte // read buffer
-	wrbuf        []byte // write buffer
+	framer       Framer
 	session      int32
 	methodMutex  sync.Mutex
 	methods      map[string]*Method
+	skipUnknown  bool // Register skips methods matching no protocol
 	sessionMutex sync.Mutex
 	sessions     map[int32]*Call
+	closing      bool // refuse new calls, guarded by sessionMutex
+	closeOnce    sync.Once
+	closeErr     error
+	closed       chan struct{}
+	ctx          context.Context // base of handler contexts, canceled by Close
+	cancel       context.CancelFunc
 	onUnknown    OnUnknownPacket
+	onError      OnHandlerError
+	onPanic      OnHandlerPanic
+
+	serverInterceptors []ServerInterceptor
+	clientInterceptors []ClientInterceptor
+
+	// concurrent mode
+	concurrency  int
+	ordered      map[string]bool
+	jobs         chan *request // to worker pool
+	jobsOnce     sync.Once
+	orderedJobs  chan *request // to the worker of ordered protocols
+	orderedOnce  sync.Once
+	handling     int32 // packets read but not dispatched yet, running and queued handlers included
+	handlerMutex sync.Mutex
+	handlerErr   error // first error of handlers, which closes the service
 }
 
 func (s *Service) nextSession() int32 {
 	return atomic.AddInt32(&s.session, 1)
 }
 
-func (s *Service) setSession(session int32, call *Call) {
+func (s *Service) setSession(session int32, call *Call) error {
 	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	if s.closing {
+		return ErrServiceClosed
+	}
 	s.sessions[session] = call
-	s.sessionMutex.Unlock()
+	return nil
+}
+
+// drained reports whether no call is pending and no packet is in flight
+func (s *Service) drained() bool {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	return len(s.sessions) == 0 && atomic.LoadInt32(&s.handling) == 0
 }
 
 func (s *Service) grabSession(session int32) *Call {
@@ -94,6 +207,15 @@ func (s *Service) grabSession(session int32) *Call {
 	return nil
 }
 
+// cancelSession removes a pending call, so its response goes to OnUnknownPacket
+func (s *Service) cancelSession(session int32) *Call {
+	call := s.grabSession(session)
+	if call != nil {
+		s.rpc.forgetSession(session)
+	}
+	return call
+}
+
 func (s *Service) setMethod(name string, method *Method) error {
 	s.methodMutex.Lock()
 	defer s.methodMutex.Unlock()
@@ -122,17 +244,23 @@ func (s *Service) register(rcvr reflect.Value, typ reflect.Type) error {
 		method := typ.Method(m)
 		protocol := s.getProtocol(module, method.Name)
 		if protocol == nil {
+			if s.skipUnknown {
+				continue
+			}
 			return fmt.Errorf("sproto:unknown service %s.%s", module, method.Name)
 		}
 
-		if err := protocol.MatchMethod(method); err != nil {
+		withContext, withError, err := protocol.matchMethod(method)
+		if err != nil {
 			return err
 		}
 
 		meth := &Method{
-			rcvr:     rcvr,
-			method:   method,
-			protocol: protocol,
+			rcvr:        rcvr,
+			fn:          method.Func,
+			protocol:    protocol,
+			withContext: withContext,
+			withError:   withError,
 		}
 		if err := s.setMethod(protocol.Name, meth); err != nil {
 			return err
@@ -154,94 +282,286 @@ func (s *Service) Register(receiver interface{}) error {
 	return nil
 }
 
+// Handle registers fn as the handler of protocol name. fn is a function like
+// a method of Register without receiver, e.g.
+// func(ctx context.Context, req *Request, resp *Response) error.
+func (s *Service) Handle(name string, fn interface{}) error {
+	protocol := s.rpc.GetProtocolByName(name)
+	if protocol == nil {
+		return ErrUnknownProtocol
+	}
+	withContext, withError, err := protocol.matchFunc(reflect.TypeOf(fn), 0, "handler of "+name)
+	if err != nil {
+		return err
+	}
+	fv := reflect.ValueOf(fn)
+	if fv.IsNil() {
+		return fmt.Errorf("sproto: handler of %s is nil", name)
+	}
+	return s.setMethod(name, &Method{
+		fn:          fv,
+		protocol:    protocol,
+		withContext: withContext,
+		withError:   withError,
+	})
+}
+
+// SetSkipUnknownMethods makes Register skip methods matching no protocol
+// instead of failing, so receivers can have unrelated exported methods.
+func (s *Service) SetSkipUnknownMethods(skip bool) {
+	s.skipUnknown = skip
+}
+
 func (s *Service) WritePacket(msg []byte) error {
+	if s.isClosed() {
+		return ErrServiceClosed
+	}
 	s.writeMutex.Lock()
 	defer s.writeMutex.Unlock()
+	return s.framer.WritePacket(s.rw, msg)
+}
 
-	sz := len(msg)
-	if sz > MSG_MAX_LEN {
-		return fmt.Errorf("sproto: message size(%d) should be less than %d", sz, MSG_MAX_LEN)
+// writeRequest writes a request packet, a failed write closes the service as
+// a response would, since the connection may carry a partial packet
+func (s *Service) writeRequest(data []byte) error {
+	err := s.WritePacket(data)
+	if err != nil && err != ErrServiceClosed && !errors.Is(err, ErrPacketTooLarge) {
+		s.Close()
 	}
-	binary.BigEndian.PutUint16(s.wrbuf[:2], uint16(sz))
-	copy(s.wrbuf[2:], msg)
-	_, err := s.rw.Write(s.wrbuf[:sz+2])
 	return err
 }
 
-func (s *Service) readPacket() (buf []byte, err error) {
+// readPacket reads a packet, which is in flight until it's dispatched, and
+// reports whether the service is shutting down
+func (s *Service) readPacket() ([]byte, bool, error) {
 	s.readMutex.Lock()
 	defer s.readMutex.Unlock()
-
-	var sz uint16
-	if err = binary.Read(s.rw, binary.BigEndian, &sz); err != nil {
-		return
+	data, err := s.framer.ReadPacket(s.rw)
+	if err != nil {
+		return nil, false, err
 	}
+	// counted along with closing, so Shutdown doesn't close a read packet
+	s.sessionMutex.Lock()
+	closing := s.closing
+	atomic.AddInt32(&s.handling, 1)
+	s.sessionMutex.Unlock()
+	return data, closing, nil
+}
 
-	var to uint16 = 0
-	buf = s.rdbuf[:sz]
-	for to < sz {
-		var n int
-		n, err = s.rw.Read(buf[to:])
-		if err != nil {
-			return
-		}
-		to += uint16(n)
+// refuse replies a request arrived during Shutdown with CodeUnavailable
+func (s *Service) refuse(protocol *Protocol, session int32) error {
+	if !protocol.HasResponse() {
+		return nil
 	}
-	return
+	data, err := s.rpc.ErrorEncode(protocol.Name, session, &RemoteError{Code: CodeUnavailable, Message: "service shutting down"})
+	if err != nil {
+		return err
+	}
+	return s.WritePacket(data)
 }
 
 // dispatch one packet
 func (s *Service) DispatchOnce() error {
-	data, err := s.readPacket()
+	if s.isClosed() {
+		return s.closedErr()
+	}
+	data, closing, err := s.readPacket()
 	if err != nil {
+		if s.isClosed() {
+			return s.closedErr()
+		}
 		return err
 	}
+	// the packet is in flight until dispatched or handed over to workers
+	handed := false
+	defer func() {
+		if !handed {
+			atomic.AddInt32(&s.handling, -1)
+		}
+	}()
 
-	mode, name, session, sp, err := s.rpc.Dispatch(data)
+	mode, name, session, sp, header, err := s.rpc.DispatchHeader(data)
 	if err != nil {
+		if mode == RpcResponseMode && errors.Is(err, ErrUnknownSession) {
+			// response of a canceled call
+			return s.onUnknown(mode, name, session, sp)
+		}
 		return err
 	}
 
 	if mode == RpcRequestMode {
 		method := s.getMethod(name)
 		if method == nil {
-			if err = s.onUnknown(mode, name, session, sp); err != nil {
-				return err
-			}
+			return s.onUnknown(mode, name, session, sp)
 		}
-		resp := method.call(sp)
-		if method.protocol.HasResponse() {
-			data, err := s.rpc.ResponseEncode(name, session, resp)
-			if err != nil {
-				return err
-			}
-			return s.WritePacket(data)
+		if closing {
+			return s.refuse(method.protocol, session)
 		}
+		req := &request{method: method, name: name, session: session, sp: sp, header: header}
+		if s.concurrency == 0 {
+			return s.serve(req)
+		}
+		handed = true
+		return s.schedule(req)
 	} else {
 		call := s.grabSession(session)
 		if call == nil {
-			if err = s.onUnknown(mode, name, session, sp); err != nil {
-				return err
-			}
+			return s.onUnknown(mode, name, session, sp)
+		}
+		if re, ok := sp.(*RemoteError); ok {
+			call.Err = re
+		} else {
+			call.Resp = sp
 		}
-		call.Resp = sp
+		call.Header = header
 		call.done()
 	}
 	return nil
 }
 
-// dispatch until error
+// serve calls the method of req and writes its response
+func (s *Service) serve(req *request) error {
+	protocol := req.method.protocol
+	ctx := newRequestContext(s.ctx, s, protocol, req.session, req.header)
+	resp, err := s.handle(ctx, req)
+	if err != nil {
+		if err := s.onError(ctx, err); err != nil {
+			return err
+		}
+		if !protocol.HasResponse() {
+			return nil
+		}
+		data, err := s.rpc.ErrorEncode(req.name, req.session, toRemoteError(err))
+		if err != nil {
+			return err
+		}
+		return s.WritePacket(data)
+	}
+	if protocol.HasResponse() {
+		if resp == nil {
+			resp = reflect.New(protocol.Response.Elem()).Interface()
+		}
+		data, err := s.rpc.ResponseEncodeHeader(req.name, req.session, resp, req.header)
+		if err != nil {
+			return err
+		}
+		return s.WritePacket(data)
+	}
+	return nil
+}
+
+// handle runs the handler of req with interceptors, and recovers its panic
+func (s *Service) handle(ctx context.Context, req *request) (resp interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.onPanic(ctx, req.method.protocol, v, debug.Stack())
+			resp, err = nil, fmt.Errorf("%w: %v", ErrHandlerPanic, v)
+		}
+	}()
+	return s.interceptRequest(ctx, req.method.protocol, req.sp, req.method.call)
+}
+
+func (s *Service) serveAsync(req *request) {
+	defer atomic.AddInt32(&s.handling, -1)
+	if err := s.serve(req); err != nil {
+		s.handlerMutex.Lock()
+		if s.handlerErr == nil {
+			s.handlerErr = err
+		}
+		s.handlerMutex.Unlock()
+		s.Close()
+	}
+}
+
+func (s *Service) worker(jobs chan *request) {
+	for {
+		select {
+		case req := <-jobs:
+			s.serveAsync(req)
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// schedule runs req in concurrent mode, workers are started on demand. req is
+// counted in handling already.
+func (s *Service) schedule(req *request) error {
+	if s.ordered[req.name] {
+		s.orderedOnce.Do(func() {
+			s.orderedJobs = make(chan *request, orderedQueueSize)
+			go s.worker(s.orderedJobs)
+		})
+		return s.enqueue(s.orderedJobs, req)
+	}
+	if s.concurrency < 0 {
+		go s.serveAsync(req)
+		return nil
+	}
+	s.jobsOnce.Do(func() {
+		s.jobs = make(chan *request)
+		for i := 0; i < s.concurrency; i++ {
+			go s.worker(s.jobs)
+		}
+	})
+	return s.enqueue(s.jobs, req)
+}
+
+func (s *Service) enqueue(jobs chan *request, req *request) error {
+	select {
+	case jobs <- req:
+		return nil
+	case <-s.closed:
+		atomic.AddInt32(&s.handling, -1)
+		return s.closedErr()
+	}
+}
+
+// SetConcurrency sets how requests are handled: inline on the dispatching
+// goroutine if n is 0 (default), on a goroutine per request if n < 0, or by a
+// pool of n workers, which blocks dispatching while all are busy. An error of
+// concurrent handlers closes the service and is returned by DispatchOnce.
+// It should be called before dispatching.
+func (s *Service) SetConcurrency(n int) {
+	s.concurrency = n
+}
+
+// SetOrderedProtocols makes requests of protocols named names handled one at
+// a time in arrival order in concurrent mode. It should be called before
+// dispatching.
+func (s *Service) SetOrderedProtocols(names ...string) {
+	s.ordered = make(map[string]bool, len(names))
+	for _, name := range names {
+		s.ordered[name] = true
+	}
+}
+
+// dispatch until error, then close the service, so pending calls fail with
+// ErrServiceClosed
 func (s *Service) Dispatch() error {
 	for {
 		if err := s.DispatchOnce(); err != nil {
+			s.Close()
 			return err
 		}
 	}
 }
 
-// unblock call a service which has a reply
+// unblock call a service which has a reply, the call is sent to done, which
+// must be buffered, when it completes; see Call for a full done.
 func (s *Service) Go(name string, req interface{}, done chan *Call) (call *Call, err error) {
-	protocol := s.rpc.GetProtocolByName(name)
+	return s.GoContext(context.Background(), name, req, done)
+}
+
+// GoContext is Go bound to ctx: if ctx is done before the response arrives,
+// the call is done with ctx.Err() and the late response goes to OnUnknownPacket
+// with its session only, as its protocol is forgotten; see
+// IgnoreUnknownResponses to drop it.
+func (s *Service) GoContext(ctx context.Context, name string, req interface{}, done chan *Call) (call *Call, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	protocol := s.rpc.GetRemoteProtocolByName(name)
 	if protocol == nil {
 		err = fmt.Errorf("sproto: call unknown service: %s", name)
 		return
@@ -252,12 +572,6 @@ func (s *Service) Go(name string, req interface{}, done chan *Call) (call *Call,
 		return
 	}
 
-	session := s.nextSession()
-	var data []byte
-	if data, err = s.rpc.RequestEncode(name, session, req); err != nil {
-		return
-	}
-
 	if done == nil {
 		done = make(chan *Call, 1)
 	} else {
@@ -266,18 +580,81 @@ func (s *Service) Go(name string, req interface{}, done chan *Call) (call *Call,
 			return
 		}
 	}
+	if len(s.clientInterceptors) == 0 {
+		return s.send(ctx, protocol, req, done)
+	}
+
 	call = &Call{
 		protocol: protocol,
 		Done:     done,
 	}
-	s.setSession(session, call)
-	s.WritePacket(data)
+	go func() {
+		call.Resp, call.Err = s.interceptCall(ctx, protocol, req, s.invoke)
+		call.done()
+	}()
 	return
 }
 
+// invoke is the Invoker of calls
+func (s *Service) invoke(ctx context.Context, protocol *Protocol, req interface{}) (interface{}, error) {
+	call, err := s.send(ctx, protocol, req, make(chan *Call, 1))
+	if err != nil {
+		return nil, err
+	}
+	call = <-call.Done
+	return call.Resp, call.Err
+}
+
+// send writes the request of a call
+func (s *Service) send(ctx context.Context, protocol *Protocol, req interface{}, done chan *Call) (call *Call, err error) {
+	session := s.nextSession()
+	var data []byte
+	if data, err = s.rpc.RequestEncodeHeader(protocol.Name, session, req, outgoingHeader(ctx)); err != nil {
+		return
+	}
+
+	call = &Call{
+		protocol: protocol,
+		session:  session,
+		Done:     done,
+	}
+	if ctx.Done() != nil {
+		call.finished = make(chan struct{})
+	}
+	if err = s.setSession(session, call); err != nil {
+		s.rpc.forgetSession(session)
+		return nil, err
+	}
+	if err = s.writeRequest(data); err != nil {
+		s.cancelSession(session)
+		return nil, err
+	}
+	if call.finished != nil {
+		go s.watchCall(ctx, call)
+	}
+	return
+}
+
+func (s *Service) watchCall(ctx context.Context, call *Call) {
+	select {
+	case <-ctx.Done():
+		if s.cancelSession(call.session) != nil {
+			call.Err = ctx.Err()
+			call.done()
+		}
+	case <-call.finished:
+	}
+}
+
 // block call a service which has a reply
 func (s *Service) Call(name string, req interface{}) (interface{}, error) {
-	call, err := s.Go(name, req, nil)
+	return s.CallContext(context.Background(), name, req)
+}
+
+// CallContext is Call bound to ctx, it returns ctx.Err() if ctx is done
+// before the response arrives.
+func (s *Service) CallContext(ctx context.Context, name string, req interface{}) (interface{}, error) {
+	call, err := s.GoContext(ctx, name, req, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -292,29 +669,178 @@ func (s *Service) Encode(name string, req interface{}) ([]byte, error) {
 
 // invoke a service which has not a reply
 func (s *Service) Invoke(name string, req interface{}) error {
-	data, err := s.rpc.RequestEncode(name, 0, req)
+	return s.InvokeContext(context.Background(), name, req)
+}
+
+// InvokeContext is Invoke with ctx, which is passed to client interceptors
+// and carries the header of the request.
+func (s *Service) InvokeContext(ctx context.Context, name string, req interface{}) error {
+	if len(s.clientInterceptors) == 0 {
+		return s.notify(ctx, name, req)
+	}
+	protocol := s.rpc.GetRemoteProtocolByName(name)
+	if protocol == nil {
+		return ErrUnknownProtocol
+	}
+	_, err := s.interceptCall(ctx, protocol, req, func(ctx context.Context, protocol *Protocol, req interface{}) (interface{}, error) {
+		return nil, s.notify(ctx, protocol.Name, req)
+	})
+	return err
+}
+
+func (s *Service) notify(ctx context.Context, name string, req interface{}) error {
+	data, err := s.rpc.RequestEncodeHeader(name, 0, req, outgoingHeader(ctx))
 	if err != nil {
 		return err
 	}
-	return s.WritePacket(data)
+	return s.writeRequest(data)
+}
+
+// closedErr is the error of a closed service
+func (s *Service) closedErr() error {
+	s.handlerMutex.Lock()
+	defer s.handlerMutex.Unlock()
+	if s.handlerErr != nil {
+		return s.handlerErr
+	}
+	return ErrServiceClosed
+}
+
+func (s *Service) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel that's closed when the service is closed.
+func (s *Service) Done() <-chan struct{} {
+	return s.closed
+}
+
+// Close stops the service immediately: it closes the underlying connection if
+// it implements io.Closer, and fails all pending calls with ErrServiceClosed.
+func (s *Service) Close() error {
+	s.closeOnce.Do(func() {
+		s.sessionMutex.Lock()
+		s.closing = true
+		calls := s.sessions
+		s.sessions = make(map[int32]*Call)
+		s.sessionMutex.Unlock()
+
+		close(s.closed)
+		s.cancel()
+		if c, ok := s.rw.(io.Closer); ok {
+			s.closeErr = c.Close()
+		}
+		for session, call := range calls {
+			s.rpc.forgetSession(session)
+			call.Err = ErrServiceClosed
+			call.done()
+		}
+	})
+	return s.closeErr
+}
+
+// Shutdown refuses new calls and waits for pending calls and running handlers
+// to complete before closing the service; the service must still be
+// dispatching meanwhile. Requests read since then are replied RemoteError of
+// CodeUnavailable. If ctx is done first, it closes the service anyway and
+// returns ctx.Err().
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.sessionMutex.Lock()
+	s.closing = true
+	s.sessionMutex.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.drained() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-s.closed:
+			return s.Close()
+		case <-ticker.C:
+		}
+	}
+	return s.Close()
 }
 
 func (s *Service) SetOnUnknownPacket(onUnknown OnUnknownPacket) {
 	s.onUnknown = onUnknown
 }
 
+// SetOnHandlerError sets the hook for errors returned by handlers, by default
+// they are replied to callers.
+func (s *Service) SetOnHandlerError(onError OnHandlerError) {
+	s.onError = onError
+}
+
+// SetFramer sets how packets are delimited on the connection, it should be
+// called before reading or writing packets. The default is a zero LengthFramer.
+func (s *Service) SetFramer(framer Framer) {
+	s.framer = framer
+}
+
+// SetOnPanic sets the hook reporting panics of handlers, they are logged by
+// default.
+func (s *Service) SetOnPanic(onPanic OnHandlerPanic) {
+	s.onPanic = onPanic
+}
+
+// Conn returns the underlying connection of the service.
+func (s *Service) Conn() io.ReadWriter {
+	return s.rw
+}
+
+// SetDecodeOptions sets options for decoding packets, it should be called
+// before dispatching.
+func (s *Service) SetDecodeOptions(opts DecodeOptions) {
+	s.rpc.SetDecodeOptions(opts)
+}
+
+// SetHeaderType sets the type of package headers, see Rpc.SetHeaderType. The
+// header of a request is given by HeaderFromContext to its handler and sent
+// back with its response; requests carry the header set by WithHeader. It
+// should be called before dispatching.
+func (s *Service) SetHeaderType(typ reflect.Type) error {
+	return s.rpc.SetHeaderType(typ)
+}
+
 func NewService(rw io.ReadWriter, protocols []*Protocol) (*Service, error) {
 	rpc, err := NewRpc(protocols)
 	if err != nil {
 		return nil, err
 	}
+	return newService(rw, rpc), nil
+}
+
+// NewPeer returns a service which registers handlers of local protocols and
+// calls the peer with remote protocols, e.g. c2s and s2c of a client.
+func NewPeer(rw io.ReadWriter, local, remote []*Protocol) (*Service, error) {
+	rpc, err := NewRpcPeer(local, remote)
+	if err != nil {
+		return nil, err
+	}
+	return newService(rw, rpc), nil
+}
+
+func newService(rw io.ReadWriter, rpc *Rpc) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Service{
 		rpc:       rpc,
 		rw:        rw,
-		rdbuf:     make([]byte, MSG_MAX_LEN),
-		wrbuf:     make([]byte, MSG_MAX_LEN+2),
+		framer:    &LengthFramer{},
 		methods:   make(map[string]*Method),
 		sessions:  make(map[int32]*Call),
+		closed:    make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
 		onUnknown: defaultOnUnknownPacket,
-	}, nil
+		onError:   defaultOnHandlerError,
+		onPanic:   defaultOnHandlerPanic,
+	}
 }
@@ -2,7 +2,14 @@ package sproto
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 type Test int
@@ -124,3 +131,610 @@ func TestBarService(t *testing.T) {
 		t.Fatal("unexpected dispatch")
 	}
 }
+
+func TestCallContextTimeout(t *testing.T) {
+	name := "test.foobar"
+	rw := bytes.NewBuffer(nil)
+
+	client, _ := NewService(rw, protocols)
+	var lateSession int32 = -1
+	client.SetOnUnknownPacket(func(mode RpcMode, name string, session int32, sp interface{}) error {
+		if mode != RpcResponseMode {
+			t.Fatalf("unexpected mode:%d", mode)
+		}
+		lateSession = session
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.CallContext(ctx, name, &FoobarRequest{What: String("hello")})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if len(client.sessions) != 0 || len(client.rpc.sessions) != 0 {
+		t.Fatal("session of canceled call leaks")
+	}
+
+	// late reply
+	server, _ := NewService(rw, protocols)
+	if err := server.Register(&inst); err != nil {
+		t.Fatalf("register service failed:%s", err)
+	}
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch late reply failed:%s", err)
+	}
+	if lateSession != client.session {
+		t.Fatalf("late reply of session %d isn't routed to OnUnknownPacket", client.session)
+	}
+}
+
+func TestIgnoreUnknownResponses(t *testing.T) {
+	if err := IgnoreUnknownResponses(RpcResponseMode, "", 1, nil); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if err := IgnoreUnknownResponses(RpcRequestMode, "test.foo", 1, nil); err == nil {
+		t.Fatal("unknown request should fail")
+	}
+}
+
+func TestGoContextCancel(t *testing.T) {
+	client, _ := NewService(bytes.NewBuffer(nil), protocols)
+	ctx, cancel := context.WithCancel(context.Background())
+	call, err := client.GoContext(ctx, "test.foo", nil, nil)
+	if err != nil {
+		t.Fatalf("client call failed:%s", err)
+	}
+	cancel()
+	if call = <-call.Done; call.Err != context.Canceled {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+	if _, err = client.GoContext(ctx, "test.foo", nil, nil); err != context.Canceled {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
+
+func TestServiceClose(t *testing.T) {
+	client, _ := NewService(bytes.NewBuffer(nil), protocols)
+	call, err := client.Go("test.foo", nil, nil)
+	if err != nil {
+		t.Fatalf("client call failed:%s", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("close failed:%s", err)
+	}
+	if call = <-call.Done; call.Err != ErrServiceClosed {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+	select {
+	case <-client.Done():
+	default:
+		t.Fatal("done channel isn't closed")
+	}
+	if _, err := client.Call("test.foo", nil); err != ErrServiceClosed {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if err := client.DispatchOnce(); err != ErrServiceClosed {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
+
+func TestServiceConnectionDrop(t *testing.T) {
+	local, remote := net.Pipe()
+	client, _ := NewService(local, protocols)
+	go func() {
+		buf := make([]byte, 64)
+		remote.Read(buf)
+		remote.Close()
+	}()
+	call, err := client.Go("test.foo", nil, nil)
+	if err != nil {
+		t.Fatalf("client call failed:%s", err)
+	}
+	if err := client.Dispatch(); err == nil {
+		t.Fatal("dispatch should fail")
+	}
+	if call = <-call.Done; call.Err != ErrServiceClosed {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+	<-client.Done()
+}
+
+func TestServiceShutdown(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, protocols)
+	call, err := client.Go("test.foo", nil, nil)
+	if err != nil {
+		t.Fatalf("client call failed:%s", err)
+	}
+	server, _ := NewService(rw, protocols)
+	server.Register(&inst)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Shutdown(context.Background())
+	}()
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("shutdown failed:%s", err)
+	}
+	if call = <-call.Done; call.Err != nil || call.Resp == nil {
+		t.Fatalf("pending call should complete, err:%v", call.Err)
+	}
+
+	// pending call without response
+	client, _ = NewService(bytes.NewBuffer(nil), protocols)
+	call, _ = client.Go("test.foo", nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if call = <-call.Done; call.Err != ErrServiceClosed {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+}
+
+// requests arriving during Shutdown are refused
+func TestServiceShutdownRefuse(t *testing.T) {
+	aToB, bToA := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	a, _ := NewService(struct {
+		io.Reader
+		io.Writer
+	}{bToA, aToB}, protocols)
+	b, _ := NewService(struct {
+		io.Reader
+		io.Writer
+	}{aToB, bToA}, protocols)
+	a.Register(&inst)
+	b.Register(&inst)
+
+	pending, _ := a.Go("test.foo", nil, nil)
+	refused, _ := b.Go("test.foobar", &FoobarRequest{What: String("hello")}, nil)
+	result := make(chan error, 1)
+	go func() {
+		result <- a.Shutdown(context.Background())
+	}()
+	for {
+		a.sessionMutex.Lock()
+		closing := a.closing
+		a.sessionMutex.Unlock()
+		if closing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, s := range []*Service{a, b, b, a} {
+		if err := s.DispatchOnce(); err != nil {
+			t.Fatalf("dispatch service failed:%s", err)
+		}
+	}
+	var re *RemoteError
+	if call := <-refused.Done; !errors.As(call.Err, &re) || re.Code != CodeUnavailable {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("shutdown failed:%s", err)
+	}
+	if call := <-pending.Done; call.Err != nil {
+		t.Fatalf("pending call should complete, err:%v", call.Err)
+	}
+}
+
+type Slow struct {
+	release chan struct{}
+	entered chan struct{} // notified when a slow request starts
+	mutex   sync.Mutex
+	order   []string
+}
+
+func (s *Slow) Wait(req *FoobarRequest, resp *FoobarResponse) {
+	switch *req.What {
+	case "slow":
+		if s.entered != nil {
+			s.entered <- struct{}{}
+		}
+		<-s.release
+	case "0":
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mutex.Lock()
+	s.order = append(s.order, *req.What)
+	s.mutex.Unlock()
+	resp.What = req.What
+}
+
+var slowProtocols = []*Protocol{
+	{
+		Type:       1,
+		Name:       "slow.wait",
+		MethodName: "Slow.Wait",
+		Request:    reflect.TypeOf(&FoobarRequest{}),
+		Response:   reflect.TypeOf(&FoobarResponse{}),
+	},
+}
+
+func newSlowPair(t *testing.T, slow *Slow, concurrency int, ordered ...string) (client, server *Service) {
+	local, remote := net.Pipe()
+	client, _ = NewService(local, slowProtocols)
+	server, _ = NewService(remote, slowProtocols)
+	if err := server.Register(slow); err != nil {
+		t.Fatalf("register service failed:%s", err)
+	}
+	server.SetConcurrency(concurrency)
+	server.SetOrderedProtocols(ordered...)
+	go client.Dispatch()
+	go server.Dispatch()
+	return
+}
+
+func TestServiceConcurrency(t *testing.T) {
+	for _, concurrency := range []int{-1, 2} {
+		slow := &Slow{release: make(chan struct{})}
+		client, server := newSlowPair(t, slow, concurrency)
+		call, err := client.Go("slow.wait", &FoobarRequest{What: String("slow")}, nil)
+		if err != nil {
+			t.Fatalf("client call failed:%s", err)
+		}
+		resp, err := client.Call("slow.wait", &FoobarRequest{What: String("fast")})
+		if err != nil || *resp.(*FoobarResponse).What != "fast" {
+			t.Fatalf("unexpected response:%v, err:%v", resp, err)
+		}
+		close(slow.release)
+		if call = <-call.Done; call.Err != nil || *call.Resp.(*FoobarResponse).What != "slow" {
+			t.Fatalf("unexpected response:%v, err:%v", call.Resp, call.Err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestServiceOrderedProtocols(t *testing.T) {
+	slow := &Slow{}
+	client, server := newSlowPair(t, slow, -1, "slow.wait")
+	defer server.Close()
+	defer client.Close()
+
+	var calls []*Call
+	expected := []string{"0", "1", "2", "3", "4"}
+	for _, what := range expected {
+		call, err := client.Go("slow.wait", &FoobarRequest{What: String(what)}, nil)
+		if err != nil {
+			t.Fatalf("client call failed:%s", err)
+		}
+		calls = append(calls, call)
+	}
+	for _, call := range calls {
+		if call = <-call.Done; call.Err != nil {
+			t.Fatalf("call failed:%s", call.Err)
+		}
+	}
+	if !reflect.DeepEqual(slow.order, expected) {
+		t.Fatalf("unexpected order:%v", slow.order)
+	}
+}
+
+type Ctx struct {
+	t *testing.T
+}
+
+var errFail = errors.New("handler failed")
+
+func (c *Ctx) Echo(ctx context.Context, req *FoobarRequest, resp *FoobarResponse) error {
+	session, ok := SessionFromContext(ctx)
+	if !ok || session == 0 {
+		c.t.Errorf("unexpected session:%d", session)
+	}
+	if p := ProtocolFromContext(ctx); p == nil || p.Name != "ctx.echo" {
+		c.t.Errorf("unexpected protocol:%v", p)
+	}
+	if ServiceFromContext(ctx) == nil {
+		c.t.Error("service isn't in context")
+	}
+	resp.What = req.What
+	return nil
+}
+
+func (c *Ctx) Fail(ctx context.Context, resp *FooResponse) error {
+	resp.Ok = Bool(false)
+	return errFail
+}
+
+var ctxProtocols = []*Protocol{
+	{
+		Type:       1,
+		Name:       "ctx.echo",
+		MethodName: "Ctx.Echo",
+		Request:    reflect.TypeOf(&FoobarRequest{}),
+		Response:   reflect.TypeOf(&FoobarResponse{}),
+	},
+	{
+		Type:       2,
+		Name:       "ctx.fail",
+		MethodName: "Ctx.Fail",
+		Response:   reflect.TypeOf(&FooResponse{}),
+	},
+}
+
+func TestContextHandler(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, ctxProtocols)
+	server, _ := NewService(rw, ctxProtocols)
+	if err := server.Register(&Ctx{t}); err != nil {
+		t.Fatalf("register service failed:%s", err)
+	}
+
+	call, _ := client.Go("ctx.echo", &FoobarRequest{What: String("hello")}, nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if call = <-call.Done; *call.Resp.(*FoobarResponse).What != "hello" {
+		t.Fatalf("unexpected response:%v", call.Resp)
+	}
+
+	// handler error is replied by default, without details
+	var handlerErr error
+	server.SetOnHandlerError(func(ctx context.Context, err error) error {
+		handlerErr = err
+		return nil
+	})
+	call, _ = client.Go("ctx.fail", nil, nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	var re *RemoteError
+	if call = <-call.Done; !errors.As(call.Err, &re) || re.Code != CodeInternal || re.Message != "internal error" || call.Resp != nil || handlerErr != errFail {
+		t.Fatalf("unexpected response:%v, err:%v", call.Resp, call.Err)
+	}
+
+	server.SetOnHandlerError(func(ctx context.Context, err error) error {
+		if p := ProtocolFromContext(ctx); p.Name != "ctx.fail" {
+			t.Errorf("unexpected protocol:%v", p)
+		}
+		return err
+	})
+	client.Go("ctx.fail", nil, nil)
+	if err := server.DispatchOnce(); err != errFail {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
+
+type Panicky int
+
+func (p *Panicky) Foo(resp *FooResponse) {
+	panic("buggy handler")
+}
+
+var panicProtocols = []*Protocol{
+	{
+		Type:       1,
+		Name:       "panicky.foo",
+		MethodName: "Panicky.Foo",
+		Response:   reflect.TypeOf(&FooResponse{}),
+	},
+}
+
+func TestHandlerPanic(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, panicProtocols)
+	server, _ := NewService(rw, panicProtocols)
+	server.Register(new(Panicky))
+
+	var panicked interface{}
+	var stack []byte
+	server.SetOnPanic(func(ctx context.Context, p *Protocol, v interface{}, s []byte) {
+		if p.Name != "panicky.foo" {
+			t.Errorf("unexpected protocol:%s", p.Name)
+		}
+		panicked, stack = v, s
+	})
+	call, _ := client.Go("panicky.foo", nil, nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if panicked != "buggy handler" || !bytes.Contains(stack, []byte("Panicky")) {
+		t.Fatalf("unexpected panic:%v\n%s", panicked, stack)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	var re *RemoteError
+	if call = <-call.Done; !errors.As(call.Err, &re) || re.Code != CodeInternal || re.Message != "internal error" {
+		t.Fatalf("unexpected error:%v", call.Err)
+	}
+
+	server.SetOnHandlerError(func(ctx context.Context, err error) error {
+		return err
+	})
+	client.Go("panicky.foo", nil, nil)
+	if err := server.DispatchOnce(); !errors.Is(err, ErrHandlerPanic) {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
+
+func TestCallDoneFull(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, protocols)
+	server, _ := NewService(rw, protocols)
+	server.Register(&inst)
+
+	done := make(chan *Call, 1)
+	done <- nil
+	client.Go("test.foo", nil, done)
+	server.DispatchOnce()
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if call := <-done; call != nil || len(done) != 0 {
+		t.Fatal("call should be discarded")
+	}
+}
+
+func TestServiceHeader(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, protocols)
+	server, _ := NewService(rw, protocols)
+	server.Register(&inst)
+	headerType := reflect.TypeOf(&udHeader{})
+	client.SetHeaderType(headerType)
+	server.SetHeaderType(headerType)
+
+	var ud int32
+	server.SetServerInterceptors(func(ctx context.Context, p *Protocol, req interface{}, handler Handler) (interface{}, error) {
+		if h, ok := HeaderFromContext(ctx).(*udHeader); ok && h.Ud != nil {
+			ud = *h.Ud
+		}
+		return handler(ctx, req)
+	})
+
+	ctx := WithHeader(context.Background(), &udHeader{Ud: Int32(42)})
+	call, err := client.GoContext(ctx, "test.foobar", &FoobarRequest{What: String("hello")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	call = <-call.Done
+	if ud != 42 || call.Err != nil {
+		t.Fatalf("unexpected ud:%d, err:%v", ud, call.Err)
+	}
+	// response carries the header of request
+	if h, ok := call.Header.(*udHeader); !ok || *h.Ud != 42 {
+		t.Fatalf("unexpected response header:%+v", call.Header)
+	}
+}
+
+func TestPeer(t *testing.T) {
+	c1, c2 := net.Pipe()
+	// types of test and ctx protocols overlap, a peer tells them by direction
+	server, err := NewPeer(c1, protocols, ctxProtocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := NewPeer(c2, ctxProtocols, protocols)
+	if err := server.Register(&inst); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Register(&Ctx{t: t}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register(&Ctx{t: t}); err == nil {
+		t.Fatal("register remote protocols should fail")
+	}
+	go server.Dispatch()
+	go client.Dispatch()
+	defer server.Close()
+	defer client.Close()
+
+	resp, err := client.Call("test.foobar", &FoobarRequest{What: String("c2s")})
+	if err != nil || *resp.(*FoobarResponse).What != "c2s" {
+		t.Fatalf("unexpected response:%v, err:%v", resp, err)
+	}
+	resp, err = server.Call("ctx.echo", &FoobarRequest{What: String("s2c")})
+	if err != nil || *resp.(*FoobarResponse).What != "s2c" {
+		t.Fatalf("unexpected response:%v, err:%v", resp, err)
+	}
+	if _, err := server.Call("test.foobar", &FoobarRequest{}); err == nil {
+		t.Fatal("call local protocol should fail")
+	}
+}
+
+func TestServiceHandle(t *testing.T) {
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, protocols)
+	server, _ := NewService(rw, protocols)
+
+	if err := server.Handle("test.foobar", func(req *FoobarRequest) {}); err == nil {
+		t.Fatal("handler without response should fail")
+	}
+	if err := server.Handle("test.foobar", "not a function"); err == nil {
+		t.Fatal("non-function handler should fail")
+	}
+	if err := server.Handle("test.unknown", func() {}); err != ErrUnknownProtocol {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	prefix := "hello, "
+	err := server.Handle("test.foobar", func(ctx context.Context, req *FoobarRequest, resp *FoobarResponse) error {
+		resp.What = String(prefix + *req.What)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Handle("test.foobar", func(req *FoobarRequest, resp *FoobarResponse) {}); err == nil {
+		t.Fatal("handle twice should fail")
+	}
+
+	call, _ := client.Go("test.foobar", &FoobarRequest{What: String("world")}, nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if call = <-call.Done; call.Err != nil || *call.Resp.(*FoobarResponse).What != "hello, world" {
+		t.Fatalf("unexpected response:%v, err:%v", call.Resp, call.Err)
+	}
+}
+
+type Mixed int
+
+func (m Mixed) Foo(resp *FooResponse) {
+	resp.Ok = Bool(true)
+}
+
+func (m Mixed) String() string {
+	return "mixed"
+}
+
+func TestSkipUnknownMethods(t *testing.T) {
+	mixedProtocols := []*Protocol{
+		{
+			Type:       1,
+			Name:       "mixed.foo",
+			MethodName: "Mixed.Foo",
+			Response:   reflect.TypeOf(&FooResponse{}),
+		},
+	}
+	rw := bytes.NewBuffer(nil)
+	client, _ := NewService(rw, mixedProtocols)
+	server, _ := NewService(rw, mixedProtocols)
+	if err := server.Register(new(Mixed)); err == nil {
+		t.Fatal("register unrelated method should fail by default")
+	}
+
+	server, _ = NewService(rw, mixedProtocols)
+	server.SetSkipUnknownMethods(true)
+	if err := server.Register(new(Mixed)); err != nil {
+		t.Fatal(err)
+	}
+	call, _ := client.Go("mixed.foo", nil, nil)
+	if err := server.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if err := client.DispatchOnce(); err != nil {
+		t.Fatalf("dispatch service failed:%s", err)
+	}
+	if call = <-call.Done; call.Err != nil || !*call.Resp.(*FooResponse).Ok {
+		t.Fatalf("unexpected response:%v, err:%v", call.Resp, call.Err)
+	}
+}
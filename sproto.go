@@ -10,6 +10,17 @@ var (
 	ErrNil       = errors.New("sproto: Encode called with nil")
 	ErrDecode    = errors.New("sproto: Decode msg failed")
 	ErrUnpack    = errors.New("sproto: Unpack data failed")
+	ErrNilMapKey = errors.New("sproto: map key is nil")
+
+	ErrDecimalOverflow = errors.New("sproto: decimal overflow")
+	ErrUnknownTag      = errors.New("sproto: unknown tag")
+
+	// limits of DecodeOptions
+	ErrDepthLimit  = errors.New("sproto: nesting too deep")
+	ErrArrayLimit  = errors.New("sproto: array too long")
+	ErrBytesLimit  = errors.New("sproto: string or binary too long")
+	ErrAllocLimit  = errors.New("sproto: allocation limit exceeded")
+	ErrFieldsLimit = errors.New("sproto: too many fields")
 )
 
 func Append(dst, src []byte) []byte {
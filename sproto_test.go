@@ -314,6 +314,32 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+func TestEncodeAppend(t *testing.T) {
+	prefix := []byte("prefix")
+	var enc Encoder
+	for _, tc := range testCases {
+		output, err := EncodeAppend(prefix, tc.Struct)
+		if err != nil {
+			t.Fatalf("test case *%s* failed with error:%s", tc.Name, err)
+		}
+		if !bytes.Equal(output[:len(prefix)], prefix) || !bytes.Equal(output[len(prefix):], tc.Data) {
+			t.Log("encoded:", output)
+			t.Log("expected:", tc.Data)
+			t.Fatalf("test case %s failed", tc.Name)
+		}
+
+		output, err = enc.Marshal(tc.Struct)
+		if err != nil {
+			t.Fatalf("test case *%s* failed with error:%s", tc.Name, err)
+		}
+		if !bytes.Equal(output, tc.Data) {
+			t.Log("encoded:", output)
+			t.Log("expected:", tc.Data)
+			t.Fatalf("test case %s failed", tc.Name)
+		}
+	}
+}
+
 func TestDecode(t *testing.T) {
 	for _, tc := range testCases {
 		sp := reflect.New(reflect.TypeOf(tc.Struct).Elem()).Interface()
@@ -360,11 +386,32 @@ func TestEmptyIntSliceDecode(t *testing.T) {
 }
 
 func BenchmarkEncode(b *testing.B) {
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		Encode(&ab)
 	}
 }
 
+func BenchmarkEncodeAppend(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		buf, _ = EncodeAppend(buf[:0], &ab)
+	}
+}
+
+// flat message encodes without allocation
+func BenchmarkEncoderFlat(b *testing.B) {
+	b.ReportAllocs()
+	var enc Encoder
+	sp := testCases[0].Struct
+	for i := 0; i < b.N; i++ {
+		if _, err := enc.Marshal(sp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkDecode(b *testing.B) {
 	var ab AddressBook
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,240 @@
+package sproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Framing is how messages are delimited in a stream.
+type Framing int
+
+const (
+	// FrameLen16 prefixes every message with 2-byte big-endian length, the
+	// same as packets of Service.
+	FrameLen16 Framing = iota
+	// FrameLen32 prefixes every message with 4-byte big-endian length.
+	FrameLen32
+	// FrameRaw concatenates messages, the size of a message is known by its
+	// headers. Packed messages can't be delimited this way.
+	FrameRaw
+)
+
+var ErrRawPacked = errors.New("sproto: raw framing doesn't support pack")
+
+// messages larger than readChunkSize are read piece by piece, so that a bad
+// length doesn't allocate a huge buffer at once
+const readChunkSize = 64 * 1024
+
+// NewEncoder returns an encoder writing messages to w with FrameLen16.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) SetFraming(framing Framing) {
+	e.framing = framing
+}
+
+// SetPack sets whether messages are packed before written.
+func (e *Encoder) SetPack(pack bool) {
+	e.pack = pack
+}
+
+// Encode writes the message of sp to the stream.
+func (e *Encoder) Encode(sp interface{}) error {
+	if e.w == nil {
+		return errors.New("sproto: Encoder has no writer")
+	}
+	if e.framing == FrameRaw && e.pack {
+		return ErrRawPacked
+	}
+
+	prefix := 0
+	switch e.framing {
+	case FrameLen16:
+		prefix = 2
+	case FrameLen32:
+		prefix = 4
+	}
+	buf := append(e.buf[:0], 0, 0, 0, 0)[:prefix]
+	buf, err := EncodeAppend(buf, sp)
+	if err != nil {
+		return err
+	}
+	if e.pack {
+		buf = append(buf[:prefix], Pack(buf[prefix:])...)
+	}
+	e.buf = buf
+
+	sz := len(buf) - prefix
+	switch e.framing {
+	case FrameLen16:
+		if sz > MSG_MAX_LEN {
+			return fmt.Errorf("sproto: message size(%d) should be less than %d", sz, MSG_MAX_LEN)
+		}
+		binary.BigEndian.PutUint16(buf, uint16(sz))
+	case FrameLen32:
+		binary.BigEndian.PutUint32(buf, uint32(sz))
+	}
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// Decoder reads messages from a stream. It reads no more than a message
+// needs, wrap r with bufio.Reader to reduce small reads of FrameRaw.
+type Decoder struct {
+	r        io.Reader
+	buf      []byte
+	framing  Framing
+	pack     bool
+	maxFrame int
+	opts     DecodeOptions
+}
+
+// NewDecoder returns a decoder reading messages from r with FrameLen16.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+func (d *Decoder) SetFraming(framing Framing) {
+	d.framing = framing
+}
+
+// SetPack sets whether messages are packed in the stream.
+func (d *Decoder) SetPack(pack bool) {
+	d.pack = pack
+}
+
+// SetMaxFrameSize limits the size of a message in the stream, larger ones
+// fail with ErrPacketTooLarge before read. 0 means DefaultMaxPacketSize.
+func (d *Decoder) SetMaxFrameSize(n int) {
+	d.maxFrame = n
+}
+
+// SetDecodeOptions sets options for decoding messages, e.g. limits. With
+// ZeroCopy or UnsafeString, every unpacked message is read into a new buffer,
+// which its fields alias, instead of reusing the buffer of the previous one.
+func (d *Decoder) SetDecodeOptions(opts DecodeOptions) {
+	d.opts = opts
+}
+
+func (d *Decoder) checkFrame(sz int) error {
+	max := d.maxFrame
+	if max <= 0 {
+		max = DefaultMaxPacketSize
+	}
+	if sz > max {
+		return fmt.Errorf("%w, size(%d) exceeds %d", ErrPacketTooLarge, sz, max)
+	}
+	return nil
+}
+
+func (d *Decoder) read(buf []byte, n int, eof bool) ([]byte, error) {
+	return readAppend(d.r, buf, n, eof)
+}
+
+// readAppend appends n bytes of r to buf. io.EOF is only returned if nothing
+// is read and eof is allowed, which means the stream ends at message boundary.
+func readAppend(r io.Reader, buf []byte, n int, eof bool) ([]byte, error) {
+	start := len(buf)
+	for n > 0 {
+		chunk := n
+		if chunk > readChunkSize {
+			chunk = readChunkSize
+		}
+		offset := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r, buf[offset:]); err != nil {
+			if err == io.EOF && (!eof || offset > start) {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf[:offset], err
+		}
+		n -= chunk
+	}
+	return buf, nil
+}
+
+func (d *Decoder) readFrame() ([]byte, error) {
+	var err error
+	buf := d.buf[:0]
+	switch d.framing {
+	case FrameLen16:
+		if buf, err = d.read(buf, 2, true); err != nil {
+			return nil, err
+		}
+		sz := int(binary.BigEndian.Uint16(buf))
+		if err = d.checkFrame(sz); err != nil {
+			return nil, err
+		}
+		return d.read(buf[:0], sz, false)
+	case FrameLen32:
+		if buf, err = d.read(buf, 4, true); err != nil {
+			return nil, err
+		}
+		sz := int(binary.BigEndian.Uint32(buf))
+		if err = d.checkFrame(sz); err != nil {
+			return nil, err
+		}
+		return d.read(buf[:0], sz, false)
+	}
+
+	// FrameRaw: headers, then a 4-byte length and data for every zero header
+	if buf, err = d.read(buf, 2, true); err != nil {
+		return nil, err
+	}
+	fn := int(readUint16(buf))
+	if err = d.checkFrame(2 + fn*2); err != nil {
+		return nil, err
+	}
+	if buf, err = d.read(buf, fn*2, false); err != nil {
+		return nil, err
+	}
+	chunks := 0
+	for i := 0; i < fn; i++ {
+		if readUint16(buf[2+i*2:]) == 0 {
+			chunks++
+		}
+	}
+	for i := 0; i < chunks; i++ {
+		offset := len(buf)
+		if buf, err = d.read(buf, 4, false); err != nil {
+			return nil, err
+		}
+		sz := int(readUint32(buf[offset:]))
+		if err = d.checkFrame(len(buf) + sz); err != nil {
+			return nil, err
+		}
+		if buf, err = d.read(buf, sz, false); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Decode reads the next message from the stream and decodes it into sp.
+// It returns io.EOF if the stream ends before a new message.
+func (d *Decoder) Decode(sp interface{}) error {
+	if d.framing == FrameRaw && d.pack {
+		return ErrRawPacked
+	}
+	buf, err := d.readFrame()
+	if buf != nil {
+		d.buf = buf
+	}
+	if err != nil {
+		return err
+	}
+	if d.pack {
+		if buf, err = Unpack(buf); err != nil {
+			return err
+		}
+	}
+	if !d.pack && (d.opts.ZeroCopy || d.opts.UnsafeString) {
+		// fields of sp alias buf
+		d.buf = nil
+	}
+	_, err = d.opts.Decode(buf, sp)
+	return err
+}
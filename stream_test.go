@@ -0,0 +1,149 @@
+package sproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStream(t *testing.T) {
+	for _, framing := range []Framing{FrameLen16, FrameLen32, FrameRaw} {
+		for _, pack := range []bool{false, true} {
+			if framing == FrameRaw && pack {
+				continue
+			}
+			var stream bytes.Buffer
+			enc := NewEncoder(&stream)
+			enc.SetFraming(framing)
+			enc.SetPack(pack)
+			for _, tc := range testCases {
+				if err := enc.Encode(tc.Struct); err != nil {
+					t.Fatalf("framing %d pack %v: encode %s failed: %s", framing, pack, tc.Name, err)
+				}
+			}
+
+			// partial reads
+			dec := NewDecoder(iotest.OneByteReader(&stream))
+			dec.SetFraming(framing)
+			dec.SetPack(pack)
+			for _, tc := range testCases {
+				sp := reflect.New(reflect.TypeOf(tc.Struct).Elem()).Interface()
+				if err := dec.Decode(sp); err != nil {
+					t.Fatalf("framing %d pack %v: decode %s failed: %s", framing, pack, tc.Name, err)
+				}
+				if !bytes.Equal(MustEncode(sp), tc.Data) {
+					t.Fatalf("framing %d pack %v: decode %s mismatch", framing, pack, tc.Name)
+				}
+			}
+			if err := dec.Decode(&Human{}); err != io.EOF {
+				t.Fatalf("framing %d pack %v: expect EOF, but get %v", framing, pack, err)
+			}
+		}
+	}
+}
+
+func TestStreamFraming(t *testing.T) {
+	tc := testCases[0]
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream)
+	if err := enc.Encode(tc.Struct); err != nil {
+		t.Fatal(err)
+	}
+	// the same as Service.WritePacket
+	data := stream.Bytes()
+	if int(binary.BigEndian.Uint16(data)) != len(tc.Data) || !bytes.Equal(data[2:], tc.Data) {
+		t.Fatalf("unexpected packet: %v", data)
+	}
+
+	enc.SetFraming(FrameRaw)
+	enc.SetPack(true)
+	if err := enc.Encode(tc.Struct); err != ErrRawPacked {
+		t.Fatalf("expect ErrRawPacked, but get %v", err)
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	for _, framing := range []Framing{FrameLen16, FrameLen32, FrameRaw} {
+		var stream bytes.Buffer
+		enc := NewEncoder(&stream)
+		enc.SetFraming(framing)
+		if err := enc.Encode(&ab); err != nil {
+			t.Fatal(err)
+		}
+		data := stream.Bytes()
+		for _, n := range []int{1, len(data) / 2, len(data) - 1} {
+			dec := NewDecoder(bytes.NewReader(data[:n]))
+			dec.SetFraming(framing)
+			if err := dec.Decode(&AddressBook{}); err != io.ErrUnexpectedEOF {
+				t.Fatalf("framing %d: truncated at %d, expect ErrUnexpectedEOF, but get %v", framing, n, err)
+			}
+		}
+	}
+}
+
+func TestStreamLimits(t *testing.T) {
+	for _, framing := range []Framing{FrameLen16, FrameLen32, FrameRaw} {
+		var stream bytes.Buffer
+		enc := NewEncoder(&stream)
+		enc.SetFraming(framing)
+		if err := enc.Encode(&ab); err != nil {
+			t.Fatal(err)
+		}
+		data := stream.Bytes()
+
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.SetFraming(framing)
+		dec.SetMaxFrameSize(len(abData) - 1)
+		if err := dec.Decode(&AddressBook{}); !errors.Is(err, ErrPacketTooLarge) {
+			t.Fatalf("framing %d: expect ErrPacketTooLarge, but get %v", framing, err)
+		}
+
+		dec = NewDecoder(bytes.NewReader(data))
+		dec.SetFraming(framing)
+		dec.SetDecodeOptions(DecodeOptions{MaxArrayLen: 1})
+		if err := dec.Decode(&AddressBook{}); !errors.Is(err, ErrArrayLimit) {
+			t.Fatalf("framing %d: expect ErrArrayLimit, but get %v", framing, err)
+		}
+	}
+
+	// a huge length fails before allocation
+	dec := NewDecoder(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	dec.SetFraming(FrameLen32)
+	if err := dec.Decode(&AddressBook{}); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("expect ErrPacketTooLarge, but get %v", err)
+	}
+}
+
+func TestStreamZeroCopy(t *testing.T) {
+	second := BlobMsg{
+		Name: "second",
+		Data: bytes.Repeat([]byte("x"), len(blobMsg.Data)),
+		Pics: [][]byte{[]byte("y"), []byte("z")},
+		Tags: []string{"a", "b"},
+	}
+	for _, framing := range []Framing{FrameLen16, FrameLen32, FrameRaw} {
+		var stream bytes.Buffer
+		enc := NewEncoder(&stream)
+		enc.SetFraming(framing)
+		enc.Encode(&blobMsg)
+		enc.Encode(&second)
+
+		dec := NewDecoder(&stream)
+		dec.SetFraming(framing)
+		dec.SetDecodeOptions(DecodeOptions{ZeroCopy: true, UnsafeString: true})
+		var first, next BlobMsg
+		if err := dec.Decode(&first); err != nil {
+			t.Fatalf("framing %d: %s", framing, err)
+		}
+		if err := dec.Decode(&next); err != nil {
+			t.Fatalf("framing %d: %s", framing, err)
+		}
+		if !reflect.DeepEqual(first, blobMsg) || !reflect.DeepEqual(next, second) {
+			t.Fatalf("framing %d: the first message is overwritten", framing)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sproto
+
+import (
+	"reflect"
+)
+
+// UnknownTagPolicy is how decoding handles tags unknown to the go type.
+type UnknownTagPolicy int
+
+const (
+	// UnknownIgnore drops unknown fields silently.
+	UnknownIgnore UnknownTagPolicy = iota
+	// UnknownError fails decoding with ErrUnknownTag.
+	UnknownError
+	// UnknownCallback passes unknown fields to DecodeOptions.OnUnknownTag.
+	UnknownCallback
+	// UnknownPreserve keeps unknown fields in the UnknownFields field of the
+	// struct, Encode writes them back verbatim. Types without such field
+	// drop unknown fields.
+	UnknownPreserve
+)
+
+// UnknownField is a raw field unknown to the go type.
+type UnknownField struct {
+	Tag   int
+	Value int    // value embedded in header, -1 if the value is in Data
+	Data  []byte // data part, without the length
+}
+
+// UnknownFields preserves unknown fields of a struct in tag order, a struct
+// has it as an untagged field:
+//
+//	type Person struct {
+//		Name    *string `sproto:"string,0"`
+//		Unknown sproto.UnknownFields
+//	}
+type UnknownFields []UnknownField
+
+var unknownFieldsType = reflect.TypeOf(UnknownFields(nil))
+
+// v is the struct with unknown tag
+func (d *decodeState) unknownTag(st *SprotoType, v reflect.Value, tag Tag, data []byte) error {
+	policy := d.opts.UnknownTags
+	if policy == UnknownIgnore || (policy == UnknownCallback && d.opts.OnUnknownTag == nil) ||
+		(policy == UnknownPreserve && st.unknown == nil) {
+		return nil
+	}
+	if policy == UnknownError {
+		return decodeErr(0, ErrUnknownTag)
+	}
+
+	f := UnknownField{Tag: int(tag.Tag), Value: -1}
+	if tag.Val != nil {
+		f.Value = int(*tag.Val)
+	}
+	if policy == UnknownCallback {
+		f.Data = data
+		return d.opts.OnUnknownTag(st.Type, f)
+	}
+	if tag.Val == nil {
+		var err error
+		if f.Data, err = d.bytes(data); err != nil {
+			return err
+		}
+	}
+	fields := v.FieldByIndex(st.unknown).Addr().Interface().(*UnknownFields)
+	*fields = append(*fields, f)
+	return nil
+}
+
+// writeUnknownFields writes fields[i:] whose tag is less than before, returns
+// index of the next field to write
+func writeUnknownFields(w *MessageWriter, fields UnknownFields, i int, before int) int {
+	for ; i < len(fields) && fields[i].Tag < before; i++ {
+		f := &fields[i]
+		if f.Value >= 0 {
+			w.header(f.Tag, uint16(2*(f.Value+1)))
+			continue
+		}
+		offset := w.beginData(f.Tag)
+		w.buf = append(w.buf, f.Data...)
+		w.endData(offset)
+	}
+	return i
+}
@@ -0,0 +1,122 @@
+package sproto
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type FullMsg struct {
+	Id    int      `sproto:"integer,0"`
+	Name  string   `sproto:"string,1"`
+	Big   int64    `sproto:"integer,2"`
+	Score float64  `sproto:"double,3"`
+	Tags  []string `sproto:"string,5,array"`
+	Ok    bool     `sproto:"boolean,7"`
+}
+
+type PartialMsg struct {
+	Name    string  `sproto:"string,1"`
+	Score   float64 `sproto:"double,3"`
+	Unknown UnknownFields
+}
+
+var fullMsg = FullMsg{
+	Id:    12,
+	Name:  "proxy",
+	Big:   1 << 40,
+	Score: 3.5,
+	Tags:  []string{"a", "bc"},
+	Ok:    true,
+}
+
+func TestUnknownIgnore(t *testing.T) {
+	data := MustEncode(&fullMsg)
+	var msg PartialMsg
+	MustDecode(data, &msg)
+	if msg.Name != "proxy" || msg.Score != 3.5 || msg.Unknown != nil {
+		t.Fatalf("unexpected %+v", msg)
+	}
+}
+
+func TestUnknownError(t *testing.T) {
+	data := MustEncode(&fullMsg)
+	var msg PartialMsg
+	_, err := DecodeOptions{UnknownTags: UnknownError}.Decode(data, &msg)
+	if !errors.Is(err, ErrUnknownTag) {
+		t.Fatalf("expected ErrUnknownTag, got %v", err)
+	}
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Type != "PartialMsg" || de.Tag != 0 {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestUnknownCallback(t *testing.T) {
+	data := MustEncode(&fullMsg)
+	var tags []int
+	opts := DecodeOptions{
+		UnknownTags: UnknownCallback,
+		OnUnknownTag: func(typ reflect.Type, f UnknownField) error {
+			if typ != reflect.TypeOf(PartialMsg{}) {
+				t.Fatalf("unexpected type %s", typ)
+			}
+			tags = append(tags, f.Tag)
+			return nil
+		},
+	}
+	var msg PartialMsg
+	if _, err := opts.Decode(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []int{0, 2, 5, 7}) || msg.Unknown != nil {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	stop := errors.New("stop")
+	opts.OnUnknownTag = func(reflect.Type, UnknownField) error { return stop }
+	if _, err := opts.Decode(data, &msg); !errors.Is(err, stop) {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+}
+
+func TestUnknownPreserve(t *testing.T) {
+	data := MustEncode(&fullMsg)
+	var msg PartialMsg
+	if _, err := (DecodeOptions{UnknownTags: UnknownPreserve}).Decode(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	expected := UnknownFields{
+		{Tag: 0, Value: 12},
+		{Tag: 2, Value: -1, Data: []byte{0, 0, 0, 0, 0, 1, 0, 0}},
+		{Tag: 5, Value: -1, Data: []byte{1, 0, 0, 0, 'a', 2, 0, 0, 0, 'b', 'c'}},
+		{Tag: 7, Value: 1},
+	}
+	if !reflect.DeepEqual(msg.Unknown, expected) {
+		t.Fatalf("unexpected unknown fields %+v", msg.Unknown)
+	}
+
+	// forward fields verbatim
+	msg.Name = "forwarded"
+	out := MustEncode(&msg)
+	var full FullMsg
+	MustDecode(out, &full)
+	expectedFull := fullMsg
+	expectedFull.Name = "forwarded"
+	if !reflect.DeepEqual(full, expectedFull) {
+		t.Fatalf("unexpected %+v", full)
+	}
+
+	msg.Name = fullMsg.Name
+	if out = MustEncode(&msg); !bytes.Equal(out, data) {
+		t.Fatalf("re-encoded message differs:\n%x\n%x", out, data)
+	}
+}
+
+func TestUnknownFieldsOrder(t *testing.T) {
+	msg := PartialMsg{Name: "x", Unknown: UnknownFields{{Tag: 1, Value: 0}}}
+	if _, err := Encode(&msg); err == nil {
+		t.Fatal("expected error for duplicated tag")
+	}
+}